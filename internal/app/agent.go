@@ -1,273 +1,291 @@
 package app
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
-	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/bbquite/mca-server/internal/collector"
 	"github.com/bbquite/mca-server/internal/handlers"
-	"github.com/bbquite/mca-server/internal/model"
 	"github.com/bbquite/mca-server/internal/service"
 	"github.com/bbquite/mca-server/internal/storage"
 	"github.com/bbquite/mca-server/internal/utils"
-	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
-const (
-	defServerHost     string = "localhost:8080"
-	defReportInterval int    = 10 // частота отправки метрик
-	defPollInterval   int    = 2  // частота опроса метрик
-	defAgentKey       string = ""
-)
-
-type agentConfig struct {
-	Host           string `json:"host"`
-	ReportInterval int    `json:"report_interval"`
-	PollInterval   int    `json:"poll_interval"`
-	Key            string `json:"KEY"`
-}
-
-func initAgentConfigENV(cfg *agentConfig) *agentConfig {
-	err := godotenv.Load()
-	if err != nil {
-		log.Print(".env file not found")
-	}
-
-	if envHOST, ok := os.LookupEnv("ADDRESS"); ok {
-		cfg.Host = envHOST
-	}
-
-	if envKEY, ok := os.LookupEnv("KEY"); ok {
-		cfg.Key = envKEY
-	}
+/*
+initCollectors создаёт включённые коллекторы по списку вида "runtime,host=10",
+где после "=" можно указать собственный интервал опроса в секундах.
+Коллекторы, не поддерживаемые текущей системой, пропускаются с предупреждением
+*/
+func initCollectors(spec string, defInterval time.Duration, logger *zap.SugaredLogger) ([]collector.Collector, error) {
+	var collectors []collector.Collector
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
 
-	if envReportInterval, ok := os.LookupEnv("REPORT_INTERVAL"); ok {
-		cfg.ReportInterval, _ = strconv.Atoi(envReportInterval)
-	}
+		name, rawInterval, hasInterval := strings.Cut(item, "=")
+		interval := defInterval
+		if hasInterval {
+			seconds, err := strconv.Atoi(rawInterval)
+			if err != nil || seconds < 1 {
+				return nil, fmt.Errorf("collector %s: invalid interval %q", name, rawInterval)
+			}
+			interval = time.Duration(seconds) * time.Second
+		}
 
-	if envPollInterval, ok := os.LookupEnv("POLL_INTERVAL"); ok {
-		cfg.PollInterval, _ = strconv.Atoi(envPollInterval)
+		c, err := collector.New(name, interval)
+		if errors.Is(err, collector.ErrorUnsupported) {
+			logger.Warnf("collector %s is disabled: %v", name, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, c)
 	}
 
-	return cfg
+	return collectors, nil
 }
 
-func collectMetrics(memStat *runtime.MemStats, services *service.MetricService, logger *zap.SugaredLogger) {
-
-	logger.Info("collecting metrics")
-	runtime.ReadMemStats(memStat)
-
-	_, err := services.AddGaugeItem("Alloc", model.Gauge(memStat.Alloc))
-	if err != nil {
-		logger.Errorf("metric saving error: Alloc = %v", memStat.Alloc)
-	}
-
-	_, err = services.AddGaugeItem("BuckHashSys", model.Gauge(memStat.BuckHashSys))
-	if err != nil {
-		logger.Errorf("metric saving error: BuckHashSys = %v", memStat.BuckHashSys)
-	}
-
-	_, err = services.AddGaugeItem("Frees", model.Gauge(memStat.Frees))
-	if err != nil {
-		logger.Errorf("metric saving error: Frees = %v", memStat.Frees)
-	}
-
-	_, err = services.AddGaugeItem("GCCPUFraction", model.Gauge(memStat.GCCPUFraction))
-	if err != nil {
-		logger.Errorf("metric saving error: Alloc = %v", memStat.GCCPUFraction)
+// runListener обслуживает HTTP запросы на address до отмены ctx
+func runListener(ctx context.Context, name string, address string, handler http.Handler, logger *zap.SugaredLogger) {
+	srv := &http.Server{
+		Addr:    address,
+		Handler: handler,
 	}
 
-	_, err = services.AddGaugeItem("GCSys", model.Gauge(memStat.GCSys))
-	if err != nil {
-		logger.Errorf("metric saving error: GCSys = %v", memStat.GCSys)
-	}
-
-	_, err = services.AddGaugeItem("HeapAlloc", model.Gauge(memStat.HeapAlloc))
-	if err != nil {
-		logger.Errorf("metric saving error: HeapAlloc = %v", memStat.HeapAlloc)
-	}
+	go func() {
+		<-ctx.Done()
 
-	_, err = services.AddGaugeItem("HeapIdle", model.Gauge(memStat.HeapIdle))
-	if err != nil {
-		logger.Errorf("metric saving error: HeapIdle = %v", memStat.HeapIdle)
-	}
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		srv.Shutdown(shutdownCtx)
+	}()
 
-	_, err = services.AddGaugeItem("HeapInuse", model.Gauge(memStat.HeapInuse))
-	if err != nil {
-		logger.Errorf("metric saving error: HeapInuse = %v", memStat.HeapInuse)
+	logger.Infof("%s listening on %s", name, address)
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorf("%s listener error: %v", name, err)
 	}
+}
 
-	_, err = services.AddGaugeItem("HeapObjects", model.Gauge(memStat.HeapObjects))
-	if err != nil {
-		logger.Errorf("metric saving error: HeapObjects = %v", memStat.HeapObjects)
-	}
+func RunAgent() error {
 
-	_, err = services.AddGaugeItem("HeapReleased", model.Gauge(memStat.HeapReleased))
+	cfg, err := LoadAgentConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		logger.Errorf("metric saving error: HeapReleased = %v", memStat.HeapReleased)
+		log.Fatalf("agent config error: %v", err)
 	}
 
-	_, err = services.AddGaugeItem("HeapSys", model.Gauge(memStat.HeapSys))
+	agentLogger, err := utils.InitLogger()
 	if err != nil {
-		logger.Errorf("metric saving error: HeapSys = %v", memStat.HeapSys)
+		log.Fatalf("agent logger init error: %v", err)
 	}
 
-	_, err = services.AddGaugeItem("LastGC", model.Gauge(memStat.LastGC))
-	if err != nil {
-		logger.Errorf("metric saving error: LastGC = %v", memStat.LastGC)
-	}
+	jsonConfig, _ := json.Marshal(cfg.Redacted())
+	agentLogger.Infof("Current agent config: %s", jsonConfig)
 
-	_, err = services.AddGaugeItem("Lookups", model.Gauge(memStat.Lookups))
+	// агенту история значений не нужна
+	db := storage.NewMemStorage(0)
+	agentServices, err := service.NewMetricService(db, false, false, "")
 	if err != nil {
-		logger.Errorf("metric saving error: Lookups = %v", memStat.Lookups)
+		log.Fatalf("service construction error: %v", err)
 	}
 
-	_, err = services.AddGaugeItem("MCacheInuse", model.Gauge(memStat.MCacheInuse))
-	if err != nil {
-		logger.Errorf("metric saving error: MCacheInuse = %v", memStat.MCacheInuse)
+	var publicKey *rsa.PublicKey
+	if cfg.CryptoKey != "" {
+		publicKey, err = utils.LoadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			log.Fatalf("crypto key loading error: %v", err)
+		}
 	}
 
-	_, err = services.AddGaugeItem("MCacheSys", model.Gauge(memStat.MCacheSys))
-	if err != nil {
-		logger.Errorf("metric saving error: MCacheSys = %v", memStat.MCacheSys)
+	var tlsConfig *tls.Config
+	if cfg.TLS {
+		tlsConfig, err = utils.NewClientTLSConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Fatalf("tls config error: %v", err)
+		}
 	}
 
-	_, err = services.AddGaugeItem("MSpanInuse", model.Gauge(memStat.MSpanInuse))
-	if err != nil {
-		logger.Errorf("metric saving error: MSpanInuse = %v", memStat.MSpanInuse)
-	}
+	// значения уже проверены в AgentConfig.validate
+	retryDelays, _ := parseRetryDelays(cfg.RetryDelays)
 
-	_, err = services.AddGaugeItem("MSpanSys", model.Gauge(memStat.MSpanSys))
-	if err != nil {
-		logger.Errorf("metric saving error: MSpanSys = %v", memStat.MSpanSys)
+	var hosts []string
+	if cfg.Host != "" || cfg.ScrapeAddress == "" {
+		hosts, err = parseServers(cfg.Host)
+		if err != nil {
+			log.Fatalf("invalid ADDRESS: %v", err)
+		}
 	}
 
-	_, err = services.AddGaugeItem("Mallocs", model.Gauge(memStat.Mallocs))
-	if err != nil {
-		logger.Errorf("metric saving error: Mallocs = %v", memStat.Mallocs)
-	}
+	// отмена ctx при остановке агента прерывает и ожидание повторов отправки
+	ctx, cancel := context.WithCancel(context.Background())
 
-	_, err = services.AddGaugeItem("NextGC", model.Gauge(memStat.NextGC))
-	if err != nil {
-		logger.Errorf("metric saving error: NextGC = %v", memStat.NextGC)
-	}
+	senders := make([]*handlers.MetricsSender, 0, len(hosts))
+	for _, host := range hosts {
+		senders = append(senders, handlers.NewMetricsSender(ctx, agentServices, handlers.SenderConfig{
+			Host:      host,
+			ShaKey:    cfg.Key,
+			Token:     cfg.Token,
+			PublicKey: publicKey,
+			TLSConfig: tlsConfig,
 
-	_, err = services.AddGaugeItem("NumForcedGC", model.Gauge(memStat.NumForcedGC))
-	if err != nil {
-		logger.Errorf("metric saving error: NumForcedGC = %v", memStat.NumForcedGC)
-	}
+			CompressMinSize: cfg.CompressMinSize,
+			CompressLevel:   cfg.CompressLevel,
 
-	_, err = services.AddGaugeItem("NumGC", model.Gauge(memStat.NumGC))
-	if err != nil {
-		logger.Errorf("metric saving error: NumGC = %v", memStat.NumGC)
+			RetryDelays: retryDelays,
+		}, agentLogger))
 	}
 
-	_, err = services.AddGaugeItem("OtherSys", model.Gauge(memStat.OtherSys))
-	if err != nil {
-		logger.Errorf("metric saving error: OtherSys = %v", memStat.OtherSys)
-	}
+	// в режиме failover все серверы делят одну очередь, в режиме fanout у каждого сервера своя
+	var deliveries []*Delivery
+	var failover *handlers.FailoverSender
 
-	_, err = services.AddGaugeItem("PauseTotalNs", model.Gauge(memStat.PauseTotalNs))
-	if err != nil {
-		logger.Errorf("metric saving error: PauseTotalNs = %v", memStat.PauseTotalNs)
+	switch {
+	case len(senders) == 0:
+		agentLogger.Info("sending to servers is disabled, metrics are served for scraping only")
+	case cfg.ServersMode == serversModeFailover || len(senders) == 1:
+		failover = handlers.NewFailoverSender(senders, agentLogger)
+		deliveries = append(deliveries, NewDelivery(strings.Join(hosts, ","), failover, nil, agentLogger))
+	default:
+		for _, sender := range senders {
+			deliveries = append(deliveries, NewDelivery(sender.Host(), sender, nil, agentLogger))
+		}
 	}
 
-	_, err = services.AddGaugeItem("StackInuse", model.Gauge(memStat.StackInuse))
-	if err != nil {
-		logger.Errorf("metric saving error: StackInuse = %v", memStat.StackInuse)
-	}
+	if cfg.OutboxPath != "" {
+		for _, d := range deliveries {
+			dir := cfg.OutboxPath
+			if len(deliveries) > 1 {
+				dir = outboxDir(cfg.OutboxPath, d.name)
+			}
 
-	_, err = services.AddGaugeItem("StackSys", model.Gauge(memStat.StackSys))
-	if err != nil {
-		logger.Errorf("metric saving error: StackSys = %v", memStat.StackSys)
+			d.box, err = openOutbox(cfg, dir, agentLogger)
+			if err != nil {
+				log.Fatalf("outbox opening error: %v", err)
+			}
+		}
 	}
 
-	_, err = services.AddGaugeItem("Sys", model.Gauge(memStat.Sys))
-	if err != nil {
-		logger.Errorf("metric saving error: NextGC = %v", memStat.Sys)
-	}
+	var wg sync.WaitGroup
 
-	_, err = services.AddGaugeItem("TotalAlloc", model.Gauge(memStat.TotalAlloc))
+	collectors, err := initCollectors(cfg.Collectors, time.Duration(cfg.PollInterval)*time.Second, agentLogger)
 	if err != nil {
-		logger.Errorf("metric saving error: NextGC = %v", memStat.TotalAlloc)
+		log.Fatalf("collectors init error: %v (available: %s)", err, strings.Join(collector.Registered(), ", "))
 	}
 
-	rndValue := rand.Intn(100)
-	_, err = services.AddGaugeItem("RandomValue", model.Gauge(rndValue))
+	// команды из EXEC_CONFIG и EXEC_COMMANDS уже проверены вместе в AgentConfig.validate
+	execCommands, err := cfg.execCommands()
 	if err != nil {
-		logger.Errorf("metric saving error: RandomValue = %v", rndValue)
+		log.Fatalf("exec collectors loading error: %v", err)
 	}
 
-	_, err = services.AddCounterItem("PollCount", model.Counter(1))
-	if err != nil {
-		logger.Errorf("metric saving error: PollCount")
+	if len(execCommands) > 0 {
+		execCollectors, err := collector.NewExecCollectors(execCommands, time.Duration(cfg.PollInterval)*time.Second)
+		if err != nil {
+			log.Fatalf("exec collectors config error: %v", err)
+		}
+		collectors = append(collectors, execCollectors...)
 	}
-}
-
-func RunAgent() error {
-
-	cfgFlags := new(agentConfig)
 
-	flag.StringVar(&cfgFlags.Host, "a", defServerHost, "server host")
-	flag.StringVar(&cfgFlags.Key, "k", defAgentKey, "KEY")
-	flag.IntVar(&cfgFlags.ReportInterval, "r", defReportInterval, "reportInterval")
-	flag.IntVar(&cfgFlags.PollInterval, "p", defPollInterval, "pollInterval")
-	flag.Parse()
+	// сбор метрик не зависит от скорости отправки
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		collector.Run(ctx, collectors, agentServices.AddMetricsPack, agentLogger)
+	}()
 
-	cfg := initAgentConfigENV(cfgFlags)
+	if cfg.SidecarAddress != "" {
+		sidecarHandler, err := handlers.NewHandler(agentServices, handlers.HandlerConfig{}, agentLogger)
+		if err != nil {
+			log.Fatalf("sidecar handler init error: %v", err)
+		}
 
-	agentLogger, err := utils.InitLogger()
-	if err != nil {
-		log.Fatalf("agent logger init error: %v", err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runListener(ctx, "sidecar", cfg.SidecarAddress, sidecarHandler.InitSidecarRoutes(), agentLogger)
+		}()
 	}
 
-	jsonConfig, _ := json.Marshal(cfg)
-	agentLogger.Infof("Current agent config: %s", jsonConfig)
+	if cfg.ScrapeAddress != "" {
+		scrapeHandler, err := handlers.NewHandler(agentServices, handlers.HandlerConfig{
+			ShaKey:       cfg.Key,
+			ReplayWindow: defScrapeReplayWindow,
+		}, agentLogger)
+		if err != nil {
+			log.Fatalf("scrape handler init error: %v", err)
+		}
 
-	db := storage.NewMemStorage()
-	agentServices, err := service.NewMetricService(db, false, false, "")
-	if err != nil {
-		log.Fatalf("service construction error: %v", err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runListener(ctx, "scrape", cfg.ScrapeAddress, scrapeHandler.InitScrapeRoutes(), agentLogger)
+		}()
+	}
+
+	// отправка на серверы отключается пустым ADDRESS, если метрики забирает сервер (SCRAPE_ADDRESS)
+	if len(deliveries) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runReports(ctx, time.Duration(cfg.ReportInterval)*time.Second, cfg.RateLimit, agentServices, deliveries, agentLogger)
+		}()
+	}
+
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runReplay(ctx, time.Duration(cfg.ReportInterval)*time.Second)
+		}()
+	}
+
+	// в режиме failover агент возвращается на приоритетный сервер, как только тот снова доступен
+	if failover != nil && len(senders) > 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			probeTicker := time.NewTicker(time.Duration(cfg.ProbeInterval) * time.Second)
+			defer probeTicker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-probeTicker.C:
+					failover.ProbeHigherPriority()
+				}
+			}
+		}()
 	}
 
-	pollTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
-	reportTicker := time.NewTicker(time.Duration(cfg.ReportInterval) * time.Second)
-
-	memStat := new(runtime.MemStats)
-
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		for {
-			select {
-			case <-pollTicker.C:
-				collectMetrics(memStat, agentServices, agentLogger)
-
-			case <-reportTicker.C:
-				// err := handlers.SendMetricsURI(agentServices, cfg.Host, agentLogger)
-				// err := handlers.SendMetricsJSON(agentServices, cfg.Host, cfg.Key, agentLogger)
-				err := handlers.SendMetricsPackJSON(agentServices, cfg.Host, cfg.Key, agentLogger)
-				if err != nil {
-					agentLogger.Errorf("Falied to make request: \n%v", err)
-				}
-			}
-		}
-	}()
-
 	sig := <-signalCh
 	agentLogger.Info("Received signal: %v\n", sig)
 
-	pollTicker.Stop()
-	reportTicker.Stop()
+	cancel()
+	wg.Wait()
 
 	agentLogger.Info("Agent shutdown gracefully")
 	return nil
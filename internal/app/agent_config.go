@@ -0,0 +1,447 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/collector"
+	"github.com/bbquite/mca-server/internal/outbox"
+	"github.com/bbquite/mca-server/internal/utils"
+	"github.com/joho/godotenv"
+)
+
+const (
+	defServerHost     string = "localhost:8080" // один или несколько адресов через запятую
+	defServersMode    string = serversModeFailover
+	defProbeInterval  int    = 10             // частота проверки /ping приоритетных серверов в режиме failover
+	defReportInterval int    = 10             // частота отправки метрик
+	defPollInterval   int    = 2              // частота опроса метрик
+	defRateLimit      int    = 1              // количество одновременно исходящих запросов
+	defCollectors     string = "runtime,host" // включённые коллекторы, см. collector.Registered()
+	defExecConfig     string = ""
+	defSidecarAddress string = "" // например 127.0.0.1:9125, пустая строка отключает приём метрик от приложений
+	defSidecarRemote  bool   = false
+	defScrapeAddress  string = "" // адрес, на котором сервер забирает метрики GET /metrics в режиме pull
+	defAgentKey       string = ""
+	defAgentToken     string = ""
+	defCryptoKey      string = ""
+	defTLS            bool   = false
+	defTLSCA          string = ""
+	defTLSCert        string = ""
+	defTLSKey         string = ""
+
+	defRetryDelays     string = "1,3,5" // секунды между повторами отправки, пустая строка отключает повторы
+	defCompressMinSize int    = 1024    // байт
+	defCompressLevel   int    = 6       // уровень gzip от 1 до 9, 0 отключает сжатие
+
+	defScrapeReplayWindow = 5 * time.Minute // окно защиты от повтора подписанных запросов сервера
+
+	defOutboxPath    string = ""       // пустой путь отключает очередь неотправленных метрик
+	defOutboxMaxSize int64  = 64 << 20 // байт
+	defOutboxMaxAge  int    = 86400    // секунд
+	defOutboxPolicy  string = "oldest"
+)
+
+/*
+AgentConfig - настройки агента. Ключи JSON совпадают с именами переменных окружения
+и используются в файле конфигурации (-c или CONFIG). Исключения - прежние ключи host,
+report_interval и poll_interval: ключи файла не зависят от регистра, а адрес сервера
+в файле можно задать и как host, и как ADDRESS.
+*/
+type AgentConfig struct {
+	Host           string `json:"host"`
+	ServersMode    string `json:"SERVERS_MODE"` // failover или fanout
+	ProbeInterval  int    `json:"PROBE_INTERVAL"`
+	ReportInterval int    `json:"report_interval"`
+	PollInterval   int    `json:"poll_interval"`
+	RateLimit      int    `json:"RATE_LIMIT"`
+	SidecarAddress string `json:"SIDECAR_ADDRESS"`
+	SidecarRemote  bool   `json:"SIDECAR_ALLOW_REMOTE"` // разрешает не локальный SIDECAR_ADDRESS, приёмник не проверяет подпись и токены
+	ScrapeAddress  string `json:"SCRAPE_ADDRESS"`
+
+	Collectors   string                  `json:"COLLECTORS"`
+	ExecConfig   string                  `json:"EXEC_CONFIG"`             // JSON файл с внешними командами, см. collector.ExecCommand
+	ExecCommands []collector.ExecCommand `json:"EXEC_COMMANDS,omitempty"` // внешние команды прямо в файле конфигурации
+
+	Key       string `json:"KEY"`
+	Token     string `json:"TOKEN"`
+	CryptoKey string `json:"CRYPTO_KEY"` // путь к публичному RSA ключу сервера в формате PEM
+
+	RetryDelays     string `json:"RETRY_DELAYS"`
+	CompressMinSize int    `json:"COMPRESS_MIN_SIZE"`
+	CompressLevel   int    `json:"COMPRESS_LEVEL"`
+
+	OutboxPath       string `json:"OUTBOX_PATH"` // каталог очереди пачек, не доставленных на сервер
+	OutboxMaxSize    int64  `json:"OUTBOX_MAX_SIZE"`
+	OutboxMaxAge     int    `json:"OUTBOX_MAX_AGE"`
+	OutboxDropPolicy string `json:"OUTBOX_DROP_POLICY"` // oldest или newest
+
+	TLS     bool   `json:"TLS"`      // отправка по https, если не задан явно, включается при заданном TLS_CA или TLS_CERT
+	TLSCA   string `json:"TLS_CA"`   // CA для проверки сертификата сервера
+	TLSCert string `json:"TLS_CERT"` // клиентский сертификат для mTLS
+	TLSKey  string `json:"TLS_KEY"`
+
+	tlsInFile bool // TLS задан в файле конфигурации явно и не включается автоматически
+}
+
+// UnmarshalJSON читает файл конфигурации, дополнительно принимая адрес сервера под ключом ADDRESS
+func (cfg *AgentConfig) UnmarshalJSON(data []byte) error {
+	type plainConfig AgentConfig
+	file := struct {
+		*plainConfig
+		Address *string `json:"ADDRESS"`
+		TLS     *bool   `json:"TLS"`
+	}{plainConfig: (*plainConfig)(cfg)}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return err
+	}
+
+	if file.Address != nil {
+		cfg.Host = *file.Address
+	}
+	if file.TLS != nil {
+		cfg.TLS = *file.TLS
+		cfg.tlsInFile = true
+	}
+	return nil
+}
+
+// execCommands возвращает внешние команды из EXEC_CONFIG и EXEC_COMMANDS
+// Redacted возвращает копию конфигурации для записи в лог, KEY и TOKEN заменены на redactedSecret
+func (cfg AgentConfig) Redacted() AgentConfig {
+	cfg.Key = redactSecret(cfg.Key)
+	cfg.Token = redactSecret(cfg.Token)
+	return cfg
+}
+
+func (cfg *AgentConfig) execCommands() ([]collector.ExecCommand, error) {
+	if cfg.ExecConfig == "" {
+		return cfg.ExecCommands, nil
+	}
+
+	commands, err := collector.LoadExecCommands(cfg.ExecConfig)
+	if err != nil {
+		return nil, err
+	}
+	return append(commands, cfg.ExecCommands...), nil
+}
+
+func defaultAgentConfig() *AgentConfig {
+	return &AgentConfig{
+		Host:             defServerHost,
+		ServersMode:      defServersMode,
+		ProbeInterval:    defProbeInterval,
+		ReportInterval:   defReportInterval,
+		PollInterval:     defPollInterval,
+		RateLimit:        defRateLimit,
+		SidecarAddress:   defSidecarAddress,
+		SidecarRemote:    defSidecarRemote,
+		ScrapeAddress:    defScrapeAddress,
+		Collectors:       defCollectors,
+		ExecConfig:       defExecConfig,
+		Key:              defAgentKey,
+		Token:            defAgentToken,
+		CryptoKey:        defCryptoKey,
+		RetryDelays:      defRetryDelays,
+		CompressMinSize:  defCompressMinSize,
+		CompressLevel:    defCompressLevel,
+		OutboxPath:       defOutboxPath,
+		OutboxMaxSize:    defOutboxMaxSize,
+		OutboxMaxAge:     defOutboxMaxAge,
+		OutboxDropPolicy: defOutboxPolicy,
+		TLS:              defTLS,
+		TLSCA:            defTLSCA,
+		TLSCert:          defTLSCert,
+		TLSKey:           defTLSKey,
+	}
+}
+
+func (cfg *AgentConfig) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Host, "a", cfg.Host, "server host")
+	fs.StringVar(&cfg.ServersMode, "servers-mode", cfg.ServersMode, "SERVERS_MODE")
+	fs.IntVar(&cfg.ProbeInterval, "probe-interval", cfg.ProbeInterval, "PROBE_INTERVAL")
+	fs.StringVar(&cfg.Key, "k", cfg.Key, "KEY")
+	fs.StringVar(&cfg.Token, "token", cfg.Token, "TOKEN")
+	fs.IntVar(&cfg.ReportInterval, "r", cfg.ReportInterval, "reportInterval")
+	fs.IntVar(&cfg.PollInterval, "p", cfg.PollInterval, "pollInterval")
+	fs.IntVar(&cfg.RateLimit, "l", cfg.RateLimit, "RATE_LIMIT")
+	fs.StringVar(&cfg.SidecarAddress, "sidecar", cfg.SidecarAddress, "SIDECAR_ADDRESS")
+	fs.BoolVar(&cfg.SidecarRemote, "sidecar-allow-remote", cfg.SidecarRemote, "SIDECAR_ALLOW_REMOTE")
+	fs.StringVar(&cfg.ScrapeAddress, "scrape", cfg.ScrapeAddress, "SCRAPE_ADDRESS")
+	fs.StringVar(&cfg.Collectors, "collectors", cfg.Collectors, "COLLECTORS")
+	fs.StringVar(&cfg.ExecConfig, "exec-config", cfg.ExecConfig, "EXEC_CONFIG")
+	fs.StringVar(&cfg.RetryDelays, "retry-delays", cfg.RetryDelays, "RETRY_DELAYS")
+	fs.IntVar(&cfg.CompressMinSize, "compress-min-size", cfg.CompressMinSize, "COMPRESS_MIN_SIZE")
+	fs.IntVar(&cfg.CompressLevel, "compress-level", cfg.CompressLevel, "COMPRESS_LEVEL")
+	fs.StringVar(&cfg.OutboxPath, "outbox", cfg.OutboxPath, "OUTBOX_PATH")
+	fs.Int64Var(&cfg.OutboxMaxSize, "outbox-max-size", cfg.OutboxMaxSize, "OUTBOX_MAX_SIZE")
+	fs.IntVar(&cfg.OutboxMaxAge, "outbox-max-age", cfg.OutboxMaxAge, "OUTBOX_MAX_AGE")
+	fs.StringVar(&cfg.OutboxDropPolicy, "outbox-drop-policy", cfg.OutboxDropPolicy, "OUTBOX_DROP_POLICY")
+	fs.StringVar(&cfg.CryptoKey, "crypto-key", cfg.CryptoKey, "CRYPTO_KEY")
+	fs.BoolVar(&cfg.TLS, "tls", cfg.TLS, "TLS")
+	fs.StringVar(&cfg.TLSCA, "tls-ca", cfg.TLSCA, "TLS_CA")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS_CERT")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS_KEY")
+}
+
+// loadConfigFile читает JSON файл конфигурации поверх текущих значений, неизвестные ключи считаются ошибкой
+func loadConfigFile(path string, target any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func lookupEnvInt(name string, target *int) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid integer %q", name, value)
+	}
+	*target = intValue
+	return nil
+}
+
+func lookupEnvInt64(name string, target *int64) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	intValue, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: invalid integer %q", name, value)
+	}
+	*target = intValue
+	return nil
+}
+
+func lookupEnvBool(name string, target *bool) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid boolean %q", name, value)
+	}
+	*target = boolValue
+	return nil
+}
+
+func lookupEnvString(name string, target *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+func initAgentConfigENV(cfg *AgentConfig) error {
+	lookupEnvString("ADDRESS", &cfg.Host)
+	lookupEnvString("SERVERS_MODE", &cfg.ServersMode)
+	lookupEnvString("KEY", &cfg.Key)
+	lookupEnvString("TOKEN", &cfg.Token)
+	lookupEnvString("CRYPTO_KEY", &cfg.CryptoKey)
+	lookupEnvString("TLS_CA", &cfg.TLSCA)
+	lookupEnvString("TLS_CERT", &cfg.TLSCert)
+	lookupEnvString("TLS_KEY", &cfg.TLSKey)
+	lookupEnvString("SIDECAR_ADDRESS", &cfg.SidecarAddress)
+	lookupEnvString("SCRAPE_ADDRESS", &cfg.ScrapeAddress)
+	lookupEnvString("COLLECTORS", &cfg.Collectors)
+	lookupEnvString("EXEC_CONFIG", &cfg.ExecConfig)
+	lookupEnvString("RETRY_DELAYS", &cfg.RetryDelays)
+	lookupEnvString("OUTBOX_PATH", &cfg.OutboxPath)
+	lookupEnvString("OUTBOX_DROP_POLICY", &cfg.OutboxDropPolicy)
+
+	return errors.Join(
+		lookupEnvInt("PROBE_INTERVAL", &cfg.ProbeInterval),
+		lookupEnvInt("REPORT_INTERVAL", &cfg.ReportInterval),
+		lookupEnvInt("POLL_INTERVAL", &cfg.PollInterval),
+		lookupEnvInt("RATE_LIMIT", &cfg.RateLimit),
+		lookupEnvInt("COMPRESS_MIN_SIZE", &cfg.CompressMinSize),
+		lookupEnvInt("COMPRESS_LEVEL", &cfg.CompressLevel),
+		lookupEnvInt64("OUTBOX_MAX_SIZE", &cfg.OutboxMaxSize),
+		lookupEnvInt("OUTBOX_MAX_AGE", &cfg.OutboxMaxAge),
+		lookupEnvBool("SIDECAR_ALLOW_REMOTE", &cfg.SidecarRemote),
+		lookupEnvBool("TLS", &cfg.TLS),
+	)
+}
+
+// validate проверяет итоговую конфигурацию и возвращает все найденные ошибки
+func (cfg *AgentConfig) validate() error {
+	var errs []error
+
+	positive := []struct {
+		name  string
+		value int
+	}{
+		{"REPORT_INTERVAL", cfg.ReportInterval},
+		{"POLL_INTERVAL", cfg.PollInterval},
+		{"RATE_LIMIT", cfg.RateLimit},
+		{"PROBE_INTERVAL", cfg.ProbeInterval},
+	}
+	for _, option := range positive {
+		if option.value < 1 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %d", option.name, option.value))
+		}
+	}
+
+	if cfg.Host == "" && cfg.ScrapeAddress == "" {
+		errs = append(errs, errors.New("ADDRESS is required unless SCRAPE_ADDRESS is set"))
+	}
+
+	// метрики агента доступны серверу только по подписанному KEY запросу
+	if cfg.ScrapeAddress != "" && cfg.Key == "" {
+		errs = append(errs, errors.New("SCRAPE_ADDRESS requires KEY"))
+	}
+
+	if cfg.ServersMode != serversModeFailover && cfg.ServersMode != serversModeFanout {
+		errs = append(errs, fmt.Errorf("SERVERS_MODE must be %s or %s, got %q", serversModeFailover, serversModeFanout, cfg.ServersMode))
+	}
+
+	// без очереди недоступный в режиме fanout сервер терял бы дельты счётчиков, принятые остальными
+	if hosts, _ := parseServers(cfg.Host); cfg.ServersMode == serversModeFanout && len(hosts) > 1 && cfg.OutboxPath == "" {
+		errs = append(errs, errors.New("SERVERS_MODE=fanout with several servers requires OUTBOX_PATH"))
+	}
+
+	// приёмник метрик от приложений не требует авторизации, поэтому по умолчанию доступен только локально
+	if cfg.SidecarAddress != "" && !cfg.SidecarRemote && !utils.IsLoopbackAddress(cfg.SidecarAddress) {
+		errs = append(errs, fmt.Errorf("SIDECAR_ADDRESS %q is not a loopback address, set SIDECAR_ALLOW_REMOTE to accept remote pushes", cfg.SidecarAddress))
+	}
+
+	if _, err := parseRetryDelays(cfg.RetryDelays); err != nil {
+		errs = append(errs, fmt.Errorf("RETRY_DELAYS: %w", err))
+	}
+
+	if cfg.CompressLevel < gzip.NoCompression || cfg.CompressLevel > gzip.BestCompression {
+		errs = append(errs, fmt.Errorf("COMPRESS_LEVEL must be in 0..9, got %d", cfg.CompressLevel))
+	}
+
+	if cfg.CompressMinSize < 0 {
+		errs = append(errs, fmt.Errorf("COMPRESS_MIN_SIZE must not be negative, got %d", cfg.CompressMinSize))
+	}
+
+	if _, err := outbox.ParseDropPolicy(cfg.OutboxDropPolicy); err != nil {
+		errs = append(errs, fmt.Errorf("OUTBOX_DROP_POLICY: %w", err))
+	}
+
+	if cfg.OutboxMaxSize < 0 || cfg.OutboxMaxAge < 0 {
+		errs = append(errs, errors.New("OUTBOX_MAX_SIZE and OUTBOX_MAX_AGE must not be negative"))
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("TLS_CERT and TLS_KEY must be set together"))
+	}
+
+	// имена команд должны быть уникальны в обоих списках вместе
+	if commands, err := cfg.execCommands(); err != nil {
+		errs = append(errs, fmt.Errorf("EXEC_CONFIG: %w", err))
+	} else if _, err := collector.NewExecCollectors(commands, time.Duration(cfg.PollInterval)*time.Second); err != nil {
+		errs = append(errs, fmt.Errorf("EXEC_CONFIG and EXEC_COMMANDS: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseRetryDelays разбирает список задержек в секундах вида "1,3,5"
+func parseRetryDelays(spec string) ([]time.Duration, error) {
+	var delays []time.Duration
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(item)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid delay %q", item)
+		}
+		delays = append(delays, time.Duration(seconds)*time.Second)
+	}
+
+	return delays, nil
+}
+
+/*
+LoadAgentConfig собирает конфигурацию агента с приоритетом: флаги > переменные окружения >
+файл конфигурации (-c или CONFIG) > значения по умолчанию, и проверяет результат.
+*/
+func LoadAgentConfig(fs *flag.FlagSet, args []string) (*AgentConfig, error) {
+	cfg := defaultAgentConfig()
+
+	var configPath string
+	fs.StringVar(&configPath, "c", "", "CONFIG")
+	cfg.bindFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// флаги уже записаны в cfg, запоминаем их, чтобы применить поверх файла и окружения
+	setFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	if err := godotenv.Load(); err != nil {
+		log.Print(".env file not found")
+	}
+
+	if _, ok := setFlags["c"]; !ok {
+		lookupEnvString("CONFIG", &configPath)
+	}
+
+	fileCfg := defaultAgentConfig()
+	if configPath != "" {
+		if err := loadConfigFile(configPath, fileCfg); err != nil {
+			return nil, err
+		}
+	}
+	*cfg = *fileCfg
+
+	if err := initAgentConfigENV(cfg); err != nil {
+		return nil, err
+	}
+
+	for name, value := range setFlags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	// TLS_CA и TLS_CERT включают TLS, только если он не задан явно, в том числе как -tls=false
+	_, tlsFlag := setFlags["tls"]
+	_, tlsEnv := os.LookupEnv("TLS")
+	if !tlsFlag && !tlsEnv && !fileCfg.tlsInFile && (cfg.TLSCA != "" || cfg.TLSCert != "") {
+		cfg.TLS = true
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
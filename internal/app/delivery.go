@@ -0,0 +1,248 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/outbox"
+	"github.com/bbquite/mca-server/internal/service"
+	"go.uber.org/zap"
+)
+
+// Режимы работы агента с несколькими серверами
+const (
+	serversModeFailover = "failover" // отправка на первый доступный сервер в порядке приоритета
+	serversModeFanout   = "fanout"   // отправка каждой пачки на все серверы
+)
+
+type PackSender interface {
+	SendMetricsPack(metricsPack model.MetricsPack) error
+}
+
+/*
+Delivery - направление доставки пачек: сервер (или группа серверов в режиме failover)
+и очередь неотправленных пачек для него
+*/
+type Delivery struct {
+	name   string
+	sender PackSender
+	box    *outbox.Outbox
+	logger *zap.SugaredLogger
+}
+
+// NewDelivery создаёт направление доставки, box равный nil отключает очередь
+func NewDelivery(name string, sender PackSender, box *outbox.Outbox, logger *zap.SugaredLogger) *Delivery {
+	return &Delivery{name: name, sender: sender, box: box, logger: logger}
+}
+
+// outboxDir возвращает каталог очереди сервера host в режиме fanout
+func outboxDir(base string, host string) string {
+	return filepath.Join(base, strings.NewReplacer(":", "_", "/", "_").Replace(host))
+}
+
+func openOutbox(cfg *AgentConfig, dir string, logger *zap.SugaredLogger) (*outbox.Outbox, error) {
+	policy, err := outbox.ParseDropPolicy(cfg.OutboxDropPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	box, err := outbox.New(dir, cfg.OutboxMaxSize, time.Duration(cfg.OutboxMaxAge)*time.Second, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("outbox %s opened, %d pending batches", dir, box.Len())
+	return box, nil
+}
+
+/*
+SendOrQueue отправляет пачку, а при временной ошибке сохраняет её в очередь.
+Пока очередь не пуста, новые пачки встают в её конец, чтобы сохранить порядок.
+Пачка, отклонённая сервером окончательно (см. handlers.IsRetryable), в очередь не попадает.
+Возвращает true, если пачка принята сервером или очередью
+*/
+func (d *Delivery) SendOrQueue(metricsPack model.MetricsPack) bool {
+	if d.box == nil || d.box.Len() == 0 {
+		err := d.sender.SendMetricsPack(metricsPack)
+		if err == nil {
+			return true
+		}
+		d.logger.Errorf("Falied to make request to %s: \n%v", d.name, err)
+
+		if !handlers.IsRetryable(err) {
+			return false
+		}
+	}
+
+	if d.box == nil {
+		return false
+	}
+
+	dropped, err := d.box.Push(metricsPack)
+	if dropped > 0 {
+		d.logger.Warnf("outbox for %s is full, %d oldest batches dropped", d.name, dropped)
+	}
+	if err != nil {
+		d.logger.Errorf("metrics pack for %s is not queued, outbox error: %v", d.name, err)
+		return false
+	}
+	return true
+}
+
+// runReplay периодически досылает пачки из очереди, пока не отменён ctx
+func (d *Delivery) runReplay(ctx context.Context, interval time.Duration) {
+	if d.box == nil {
+		return
+	}
+
+	replayTicker := time.NewTicker(interval)
+	defer replayTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-replayTicker.C:
+			if d.box.Len() == 0 {
+				continue
+			}
+
+			stats, err := d.box.Replay(d.sender.SendMetricsPack, handlers.IsRetryable)
+			if stats.Sent > 0 || stats.Expired > 0 || stats.Rejected > 0 {
+				d.logger.Infof("outbox replay to %s: %d batches sent, %d expired, %d rejected, %d pending",
+					d.name, stats.Sent, stats.Expired, stats.Rejected, d.box.Len())
+			}
+			if err != nil {
+				d.logger.Errorf("outbox replay to %s error: %v", d.name, err)
+			}
+		}
+	}
+}
+
+/*
+DeliverAll отправляет пачку по всем направлениям одновременно и возвращает true,
+если её принял хотя бы один сервер или очередь. Иначе дельты счётчиков вернутся
+в следующий снимок, и серверы, уже получившие пачку, посчитали бы их дважды.
+Поэтому в режиме fanout у каждого сервера должна быть очередь (см. AgentConfig.validate):
+без неё временно недоступный сервер потерял бы дельты, принятые остальными
+*/
+func DeliverAll(deliveries []*Delivery, metricsPack model.MetricsPack, logger *zap.SugaredLogger) bool {
+	if len(deliveries) == 1 {
+		return deliveries[0].SendOrQueue(metricsPack)
+	}
+
+	var wg sync.WaitGroup
+	accepted := make([]bool, len(deliveries))
+
+	for i, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			accepted[i] = d.SendOrQueue(metricsPack)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, ok := range accepted {
+		if !ok {
+			failed = append(failed, deliveries[i].name)
+		}
+	}
+
+	if len(failed) == len(deliveries) {
+		return false
+	}
+	if len(failed) > 0 {
+		logger.Errorf("metrics pack is lost for %s", strings.Join(failed, ", "))
+	}
+	return true
+}
+
+// parseServers разбирает список адресов серверов через запятую
+func parseServers(spec string) ([]string, error) {
+	var hosts []string
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.TrimSpace(host)
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no server address in %q", spec)
+	}
+	return hosts, nil
+}
+
+/*
+runReports раз в interval готовит снимок метрик и передаёт его rateLimit воркерам,
+которые отправляют пачку по всем направлениям. Возвращает управление после отмены ctx
+и обработки уже подготовленных снимков
+*/
+func runReports(ctx context.Context, interval time.Duration, rateLimit int, services *service.MetricService, deliveries []*Delivery, logger *zap.SugaredLogger) {
+	var wg sync.WaitGroup
+	jobs := make(chan *service.MetricsSnapshot, rateLimit)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		reportTicker := time.NewTicker(interval)
+		defer reportTicker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reportTicker.C:
+				snapshot, err := services.SnapshotMetrics()
+				if err != nil {
+					logger.Errorf("metrics snapshot preparing error: %v", err)
+					continue
+				}
+
+				// время измерения нужно серверу, если пачка будет доставлена из очереди позже
+				ts := time.Now().UnixMilli()
+				for i := range snapshot.Metrics {
+					snapshot.Metrics[i].TS = &ts
+				}
+
+				select {
+				case jobs <- snapshot:
+				case <-ctx.Done():
+					services.ReleaseSnapshot(snapshot)
+					return
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < rateLimit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for snapshot := range jobs {
+				// дельты счётчиков вычитаются, только если пачку принял сервер или очередь
+				if !DeliverAll(deliveries, snapshot.Metrics, logger) {
+					services.ReleaseSnapshot(snapshot)
+					continue
+				}
+
+				err := services.CommitSnapshot(snapshot)
+				if err != nil {
+					logger.Errorf("metrics snapshot commit error: %v", err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -2,105 +2,35 @@ package app
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/bbquite/mca-server/internal/auth"
 	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/ingest"
 	"github.com/bbquite/mca-server/internal/service"
 	"github.com/bbquite/mca-server/internal/storage"
 	"github.com/bbquite/mca-server/internal/utils"
 	"github.com/go-chi/chi/v5"
-	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
-const (
-	defHost            string = "localhost:8080"
-	defStoreInterval   int64  = 300
-	defFileStoragePath string = "backup.json"
-	defRestore         bool   = true
-	defDatabase        string = ""
-	defKey             string = ""
-)
-
-type serverConfig struct {
-	Host            string `json:"HOST"`
-	StoreInterval   int64  `json:"STORE_INTERVAL"`
-	FileStoragePath string `json:"FILE_STORAGE_PATH"`
-	Restore         bool   `json:"RESTORE"`
-	DatabaseDSN     string `json:"DATABASE_DSN"`
-	Key             string `json:"KEY"`
-
-	IsDatabaseUsage bool `json:"DBUsage"`
-	IsSyncSaving    bool `json:"SyncSaving"`
-}
-
-func initServerConfigENV(cfg *serverConfig) *serverConfig {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Print(".env file not found")
-	}
-
-	if envHOST, ok := os.LookupEnv("ADDRESS"); ok {
-		cfg.Host = envHOST
-	}
-
-	if envKEY, ok := os.LookupEnv("KEY"); ok {
-		cfg.Key = envKEY
-	}
-
-	if envSTOREINTERVAL, ok := os.LookupEnv("STORE_INTERVAL"); ok {
-		storeInterval, err := strconv.ParseInt(envSTOREINTERVAL, 10, 64)
-		if err == nil {
-			cfg.StoreInterval = storeInterval
-		}
-	}
-
-	if envFILESTORAGEPATH, ok := os.LookupEnv("FILE_STORAGE_PATH"); ok {
-		cfg.FileStoragePath = envFILESTORAGEPATH
-	}
-
-	if envRESTORE, ok := os.LookupEnv("RESTORE"); ok {
-		boolValue, err := strconv.ParseBool(envRESTORE)
-		if err == nil {
-			cfg.Restore = boolValue
-		}
-	}
-
-	if envDATABASE, ok := os.LookupEnv("DATABASE_DSN"); ok {
-		cfg.DatabaseDSN = envDATABASE
-	}
-
-	cfg.IsDatabaseUsage = false
-	if cfg.DatabaseDSN != "" {
-		cfg.IsDatabaseUsage = true
-	}
-
-	cfg.IsSyncSaving = false
-	if cfg.StoreInterval == 0 && !cfg.IsDatabaseUsage {
-		cfg.IsSyncSaving = true
-	}
-
-	if cfg.IsDatabaseUsage {
-		cfg.Restore = false
-	}
-
-	return cfg
-}
-
 type server struct {
 	httpServer *http.Server
 }
 
-func (s *server) runHTTPSever(cfg *serverConfig, mux *chi.Mux, service *service.MetricService, logger *zap.SugaredLogger) error {
+func (s *server) runHTTPSever(cfg *ServerConfig, mux *chi.Mux, service *service.MetricService, scraper *handlers.Scraper, logger *zap.SugaredLogger) error {
 
 	s.httpServer = &http.Server{
 		Addr:           cfg.Host,
@@ -113,12 +43,54 @@ func (s *server) runHTTPSever(cfg *serverConfig, mux *chi.Mux, service *service.
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// при TLS_CERT без TLS_KEY (и наоборот) сервер не запускается, а не переходит молча на http
+	tlsConfig, err := utils.NewServerTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+	if err != nil {
+		return err
+	}
+	s.httpServer.TLSConfig = tlsConfig
+	useTLS := tlsConfig != nil
+
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if useTLS {
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("error occured while running http server: %v", err)
 		}
 	}()
 
+	// дополнительные способы приёма метрик (statsd, graphite, опрос агентов) останавливаются вместе с сервером
+	ingestCtx, ingestCancel := context.WithCancel(context.Background())
+	defer ingestCancel()
+	var ingestWG sync.WaitGroup
+
+	if cfg.StatsdAddress != "" {
+		statsdServer, err := ingest.NewStatsdServer(cfg.StatsdAddress, time.Duration(cfg.StatsdFlushInterval)*time.Second, service, logger)
+		if err != nil {
+			return err
+		}
+
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			if err := statsdServer.Run(ingestCtx); err != nil {
+				log.Fatalf("error occured while running statsd listener: %v", err)
+			}
+		}()
+	}
+
+	if scraper != nil {
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			scraper.Run(ingestCtx)
+		}()
+	}
+
 	if cfg.StoreInterval > 0 && !cfg.IsDatabaseUsage {
 		go func() {
 			for {
@@ -140,6 +112,24 @@ func (s *server) runHTTPSever(cfg *serverConfig, mux *chi.Mux, service *service.
 		}
 	}
 
+	if cfg.GraphiteAddress != "" {
+		var counterPatterns []string
+		for _, pattern := range strings.Split(cfg.GraphiteCounterPatterns, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				counterPatterns = append(counterPatterns, pattern)
+			}
+		}
+
+		graphiteServer := ingest.NewGraphiteServer(cfg.GraphiteAddress, counterPatterns, service, logger)
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			if err := graphiteServer.Run(ingestCtx); err != nil {
+				log.Fatalf("error occured while running graphite listener: %v", err)
+			}
+		}()
+	}
+
 	sig := <-signalCh
 	logger.Info("Received signal: %v\n", sig)
 
@@ -150,6 +140,9 @@ func (s *server) runHTTPSever(cfg *serverConfig, mux *chi.Mux, service *service.
 		log.Fatalf("Server shutdown failed: %v\n", err)
 	}
 
+	ingestCancel()
+	ingestWG.Wait()
+
 	if !cfg.IsDatabaseUsage {
 		logger.Debugf("Export storage to %s", cfg.FileStoragePath)
 		err := service.SaveToFile(cfg.FileStoragePath)
@@ -167,16 +160,10 @@ func RunServer() {
 
 	ctx := context.Background()
 
-	cfgFlags := new(serverConfig)
-	flag.StringVar(&cfgFlags.Host, "a", defHost, "HOST")
-	flag.Int64Var(&cfgFlags.StoreInterval, "i", defStoreInterval, "STORE_INTERVAL")
-	flag.StringVar(&cfgFlags.FileStoragePath, "f", defFileStoragePath, "FILE_STORAGE_PATH")
-	flag.BoolVar(&cfgFlags.Restore, "r", defRestore, "RESTORE")
-	flag.StringVar(&cfgFlags.DatabaseDSN, "d", defDatabase, "DATABASE_DSN")
-	flag.StringVar(&cfgFlags.Key, "k", defKey, "KEY")
-	flag.Parse()
-
-	cfg := initServerConfigENV(cfgFlags)
+	cfg, err := LoadServerConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("server config error: %v", err)
+	}
 
 	serverLogger, err := utils.InitLogger()
 	if err != nil {
@@ -203,21 +190,90 @@ func RunServer() {
 		}
 
 	} else {
-		storageInstance := storage.NewMemStorage()
+		storageInstance := storage.NewMemStorage(cfg.HistoryLimit)
 		serv, err = service.NewMetricService(storageInstance, cfg.IsSyncSaving, cfg.IsDatabaseUsage, cfg.FileStoragePath)
 		if err != nil {
 			log.Fatalf("service construction error: %v", err)
 		}
 	}
 
-	handler, err := handlers.NewHandler(serv, cfg.Key, serverLogger)
+	var privateKey *rsa.PrivateKey
+	if cfg.CryptoKey != "" {
+		privateKey, err = utils.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			log.Fatalf("crypto key loading error: %v", err)
+		}
+	}
+
+	var trustedSubnet *net.IPNet
+	if cfg.TrustedSubnet != "" {
+		_, trustedSubnet, err = net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			log.Fatalf("trusted subnet parsing error: %v", err)
+		}
+	}
+
+	var tokens *auth.TokenRegistry
+	if cfg.TokensFile != "" {
+		tokens, err = auth.NewTokenRegistry(cfg.TokensFile)
+		if err != nil {
+			log.Fatalf("token registry loading error: %v", err)
+		}
+
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		go tokens.ReloadOn(hupCh, func(err error) {
+			if err != nil {
+				serverLogger.Errorf("token registry reload error: %v", err)
+				return
+			}
+			serverLogger.Infof("token registry reloaded: %d tokens", tokens.Len())
+		})
+	}
+
+	var scraper *handlers.Scraper
+	if cfg.ScrapeTargets != "" {
+		var targets []string
+		for _, target := range strings.Split(cfg.ScrapeTargets, ",") {
+			if target = strings.TrimSpace(target); target != "" {
+				targets = append(targets, target)
+			}
+		}
+
+		// агенты за https проверяются по TLS_CLIENT_CA, которым подписаны их сертификаты,
+		// а сервер предъявляет им свой TLS_CERT как клиентский сертификат
+		var scrapeTLS *tls.Config
+		if cfg.TLSClientCA != "" || cfg.TLSCert != "" {
+			scrapeTLS, err = utils.NewClientTLSConfig(cfg.TLSClientCA, cfg.TLSCert, cfg.TLSKey)
+			if err != nil {
+				log.Fatalf("scrape TLS config error: %v", err)
+			}
+		}
+		scraper = handlers.NewScraper(serv, targets, time.Duration(cfg.ScrapeInterval)*time.Second, cfg.Key, scrapeTLS, serverLogger)
+	}
+
+	handler, err := handlers.NewHandler(serv, handlers.HandlerConfig{
+		ShaKey:         cfg.Key,
+		ReplayWindow:   time.Duration(cfg.ReplayWindow) * time.Second,
+		LegacyBodySign: cfg.LegacyBodySign,
+		PrivateKey:     privateKey,
+		TrustedSubnet:  trustedSubnet,
+		Tokens:         tokens,
+		Scraper:        scraper,
+	}, serverLogger)
 	if err != nil {
 		log.Fatalf("handler construction error: %v", err)
 	}
 
-	jsonConfig, _ := json.Marshal(cfg)
+	if tokens == nil && (cfg.Key != "" || privateKey != nil || trustedSubnet != nil) {
+		serverLogger.Info("POST /write is disabled: set TOKENS_FILE to accept line protocol alongside KEY, CRYPTO_KEY or TRUSTED_SUBNET")
+	}
+
+	jsonConfig, _ := json.Marshal(cfg.Redacted())
 	serverLogger.Infof("Server run with config: %s", jsonConfig)
 
 	srv := new(server)
-	srv.runHTTPSever(cfg, handler.InitChiRoutes(), serv, serverLogger)
+	if err := srv.runHTTPSever(cfg, handler.InitChiRoutes(), serv, scraper, serverLogger); err != nil {
+		log.Fatalf("server run error: %v", err)
+	}
 }
@@ -0,0 +1,356 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+
+	"github.com/bbquite/mca-server/internal/storage"
+	"github.com/joho/godotenv"
+)
+
+const (
+	defHost            string = "localhost:8080"
+	defStoreInterval   int64  = 300
+	defFileStoragePath string = "backup.json"
+	defRestore         bool   = true
+	defHistoryLimit    int    = storage.DefaultHistoryLimit // точек истории на метрику, 0 отключает историю
+	defDatabase        string = ""
+	defKey             string = ""
+	defReplayWindow    int64  = 300
+	defLegacyBodySign  bool   = false
+	defCryptoKeyPath   string = ""
+	defServerTLSCert   string = ""
+	defServerTLSKey    string = ""
+	defTLSClientCA     string = ""
+	defTrustedSubnet   string = ""
+	defTokensFile      string = ""
+
+	defStatsdAddress       string = ""
+	defStatsdFlushInterval int64  = 1
+
+	defGraphiteAddress         string = ""
+	defGraphiteCounterPatterns string = ""
+
+	defScrapeTargets  string = ""
+	defScrapeInterval int64  = 10
+)
+
+/*
+ServerConfig - настройки сервера. Ключи JSON используются в файле конфигурации (-c или CONFIG)
+и совпадают с именами переменных окружения, кроме HOST (переменная ADDRESS).
+*/
+type ServerConfig struct {
+	Host            string `json:"HOST"`
+	StoreInterval   int64  `json:"STORE_INTERVAL"`
+	FileStoragePath string `json:"FILE_STORAGE_PATH"`
+	Restore         bool   `json:"RESTORE"`
+	HistoryLimit    int    `json:"HISTORY_LIMIT"` // старые точки сверх лимита отбрасываются, история сохраняется в FILE_STORAGE_PATH.history
+	DatabaseDSN     string `json:"DATABASE_DSN"`
+	Key             string `json:"KEY"`
+	ReplayWindow    int64  `json:"REPLAY_WINDOW"`    // секунды, 0 - без защиты от повтора подписанных запросов
+	LegacyBodySign  bool   `json:"LEGACY_BODY_SIGN"` // принимать от старых агентов подпись только тела, только при REPLAY_WINDOW=0
+	CryptoKey       string `json:"CRYPTO_KEY"`       // путь к приватному RSA ключу в формате PEM
+	TLSCert         string `json:"TLS_CERT"`
+	TLSKey          string `json:"TLS_KEY"`
+	TLSClientCA     string `json:"TLS_CLIENT_CA"`  // при заданном CA агенты обязаны предъявить клиентский сертификат
+	TrustedSubnet   string `json:"TRUSTED_SUBNET"` // CIDR, из которого разрешена запись метрик (по X-Real-IP)
+	TokensFile      string `json:"TOKENS_FILE"`    // JSON реестр токенов доступа, перечитывается по SIGHUP
+
+	StatsdAddress       string `json:"STATSD_ADDRESS"`
+	StatsdFlushInterval int64  `json:"STATSD_FLUSH_INTERVAL"`
+
+	GraphiteAddress         string `json:"GRAPHITE_ADDRESS"`
+	GraphiteCounterPatterns string `json:"GRAPHITE_COUNTER_PATTERNS"` // шаблоны через запятую, например "jobs.*.runs,*.errors"
+
+	ScrapeTargets  string `json:"SCRAPE_TARGETS"` // адреса агентов через запятую для опроса в режиме pull, https:// проверяется по TLS_CLIENT_CA
+	ScrapeInterval int64  `json:"SCRAPE_INTERVAL"`
+
+	// вычисляются при загрузке, в файле конфигурации не допускаются
+	IsDatabaseUsage bool `json:"DBUsage"`
+	IsSyncSaving    bool `json:"SyncSaving"`
+}
+
+// UnmarshalJSON читает файл конфигурации и отклоняет вычисляемые поля DBUsage и SyncSaving
+func (cfg *ServerConfig) UnmarshalJSON(data []byte) error {
+	type plainConfig ServerConfig
+	file := struct {
+		*plainConfig
+		IsDatabaseUsage *bool `json:"DBUsage"`
+		IsSyncSaving    *bool `json:"SyncSaving"`
+	}{plainConfig: (*plainConfig)(cfg)}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return err
+	}
+
+	if file.IsDatabaseUsage != nil || file.IsSyncSaving != nil {
+		return errors.New("DBUsage and SyncSaving are derived from DATABASE_DSN and STORE_INTERVAL and cannot be set in the config file")
+	}
+	return nil
+}
+
+// redactedSecret заменяет значения секретов в конфигурации, которая пишется в лог
+const redactedSecret = "***"
+
+// dsnPasswordPattern находит пароль в DSN вида "host=... password=..."
+var dsnPasswordPattern = regexp.MustCompile(`password=\S+`)
+
+func redactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// redactDSN скрывает пароль в DATABASE_DSN в формате URL или key=value
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "password="+redactedSecret)
+}
+
+// Redacted возвращает копию конфигурации для записи в лог без KEY и пароля DATABASE_DSN
+func (cfg ServerConfig) Redacted() ServerConfig {
+	cfg.Key = redactSecret(cfg.Key)
+	cfg.DatabaseDSN = redactDSN(cfg.DatabaseDSN)
+	return cfg
+}
+
+func defaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Host:                    defHost,
+		StoreInterval:           defStoreInterval,
+		FileStoragePath:         defFileStoragePath,
+		Restore:                 defRestore,
+		HistoryLimit:            defHistoryLimit,
+		DatabaseDSN:             defDatabase,
+		Key:                     defKey,
+		ReplayWindow:            defReplayWindow,
+		LegacyBodySign:          defLegacyBodySign,
+		CryptoKey:               defCryptoKeyPath,
+		TLSCert:                 defServerTLSCert,
+		TLSKey:                  defServerTLSKey,
+		TLSClientCA:             defTLSClientCA,
+		TrustedSubnet:           defTrustedSubnet,
+		TokensFile:              defTokensFile,
+		StatsdAddress:           defStatsdAddress,
+		StatsdFlushInterval:     defStatsdFlushInterval,
+		GraphiteAddress:         defGraphiteAddress,
+		GraphiteCounterPatterns: defGraphiteCounterPatterns,
+		ScrapeTargets:           defScrapeTargets,
+		ScrapeInterval:          defScrapeInterval,
+	}
+}
+
+func (cfg *ServerConfig) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Host, "a", cfg.Host, "HOST")
+	fs.Int64Var(&cfg.StoreInterval, "i", cfg.StoreInterval, "STORE_INTERVAL")
+	fs.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "FILE_STORAGE_PATH")
+	fs.BoolVar(&cfg.Restore, "r", cfg.Restore, "RESTORE")
+	fs.IntVar(&cfg.HistoryLimit, "history-limit", cfg.HistoryLimit, "HISTORY_LIMIT")
+	fs.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "DATABASE_DSN")
+	fs.StringVar(&cfg.Key, "k", cfg.Key, "KEY")
+	fs.Int64Var(&cfg.ReplayWindow, "replay-window", cfg.ReplayWindow, "REPLAY_WINDOW")
+	fs.BoolVar(&cfg.LegacyBodySign, "legacy-body-sign", cfg.LegacyBodySign, "LEGACY_BODY_SIGN")
+	fs.StringVar(&cfg.CryptoKey, "crypto-key", cfg.CryptoKey, "CRYPTO_KEY")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS_CERT")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS_KEY")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "TLS_CLIENT_CA")
+	fs.StringVar(&cfg.TrustedSubnet, "t", cfg.TrustedSubnet, "TRUSTED_SUBNET")
+	fs.StringVar(&cfg.TokensFile, "tokens", cfg.TokensFile, "TOKENS_FILE")
+	fs.StringVar(&cfg.StatsdAddress, "statsd", cfg.StatsdAddress, "STATSD_ADDRESS")
+	fs.Int64Var(&cfg.StatsdFlushInterval, "statsd-flush", cfg.StatsdFlushInterval, "STATSD_FLUSH_INTERVAL")
+	fs.StringVar(&cfg.GraphiteAddress, "graphite", cfg.GraphiteAddress, "GRAPHITE_ADDRESS")
+	fs.StringVar(&cfg.GraphiteCounterPatterns, "graphite-counters", cfg.GraphiteCounterPatterns, "GRAPHITE_COUNTER_PATTERNS")
+	fs.StringVar(&cfg.ScrapeTargets, "scrape-targets", cfg.ScrapeTargets, "SCRAPE_TARGETS")
+	fs.Int64Var(&cfg.ScrapeInterval, "scrape-interval", cfg.ScrapeInterval, "SCRAPE_INTERVAL")
+}
+
+func initServerConfigENV(cfg *ServerConfig) error {
+	lookupEnvString("ADDRESS", &cfg.Host)
+	lookupEnvString("KEY", &cfg.Key)
+	lookupEnvString("CRYPTO_KEY", &cfg.CryptoKey)
+	lookupEnvString("TLS_CERT", &cfg.TLSCert)
+	lookupEnvString("TLS_KEY", &cfg.TLSKey)
+	lookupEnvString("TLS_CLIENT_CA", &cfg.TLSClientCA)
+	lookupEnvString("TRUSTED_SUBNET", &cfg.TrustedSubnet)
+	lookupEnvString("TOKENS_FILE", &cfg.TokensFile)
+	lookupEnvString("FILE_STORAGE_PATH", &cfg.FileStoragePath)
+	lookupEnvString("DATABASE_DSN", &cfg.DatabaseDSN)
+	lookupEnvString("STATSD_ADDRESS", &cfg.StatsdAddress)
+	lookupEnvString("GRAPHITE_ADDRESS", &cfg.GraphiteAddress)
+	lookupEnvString("GRAPHITE_COUNTER_PATTERNS", &cfg.GraphiteCounterPatterns)
+	lookupEnvString("SCRAPE_TARGETS", &cfg.ScrapeTargets)
+
+	return errors.Join(
+		lookupEnvInt64("STORE_INTERVAL", &cfg.StoreInterval),
+		lookupEnvInt64("REPLAY_WINDOW", &cfg.ReplayWindow),
+		lookupEnvBool("RESTORE", &cfg.Restore),
+		lookupEnvBool("LEGACY_BODY_SIGN", &cfg.LegacyBodySign),
+		lookupEnvInt("HISTORY_LIMIT", &cfg.HistoryLimit),
+		lookupEnvInt64("STATSD_FLUSH_INTERVAL", &cfg.StatsdFlushInterval),
+		lookupEnvInt64("SCRAPE_INTERVAL", &cfg.ScrapeInterval),
+	)
+}
+
+// validate проверяет итоговую конфигурацию и возвращает все найденные ошибки
+func (cfg *ServerConfig) validate() error {
+	var errs []error
+
+	if cfg.Host == "" {
+		errs = append(errs, errors.New("HOST must not be empty"))
+	}
+
+	nonNegative := []struct {
+		name  string
+		value int64
+	}{
+		{"STORE_INTERVAL", cfg.StoreInterval},
+		{"REPLAY_WINDOW", cfg.ReplayWindow},
+	}
+	for _, option := range nonNegative {
+		if option.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", option.name, option.value))
+		}
+	}
+
+	// с защитой от повтора время и nonce обязательны, подпись только тела всё равно будет отклонена
+	if cfg.LegacyBodySign && cfg.ReplayWindow > 0 {
+		errs = append(errs, errors.New("LEGACY_BODY_SIGN requires REPLAY_WINDOW=0"))
+	}
+
+	if cfg.HistoryLimit < 0 {
+		errs = append(errs, fmt.Errorf("HISTORY_LIMIT must not be negative, got %d", cfg.HistoryLimit))
+	}
+
+	positive := []struct {
+		name  string
+		value int64
+	}{
+		{"STATSD_FLUSH_INTERVAL", cfg.StatsdFlushInterval},
+		{"SCRAPE_INTERVAL", cfg.ScrapeInterval},
+	}
+	for _, option := range positive {
+		if option.value < 1 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %d", option.name, option.value))
+		}
+	}
+
+	// агенты отдают метрики только по запросу, подписанному KEY
+	if cfg.ScrapeTargets != "" && cfg.Key == "" {
+		errs = append(errs, errors.New("SCRAPE_TARGETS requires KEY"))
+	}
+
+	if cfg.DatabaseDSN == "" && cfg.FileStoragePath == "" {
+		errs = append(errs, errors.New("FILE_STORAGE_PATH is required unless DATABASE_DSN is set"))
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("TLS_CERT and TLS_KEY must be set together"))
+	}
+
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		errs = append(errs, errors.New("TLS_CLIENT_CA requires TLS_CERT and TLS_KEY"))
+	}
+
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("TRUSTED_SUBNET: %w", err))
+		}
+	}
+
+	// файлы читаются позже при запуске, отсутствие любого из них сообщается сразу вместе с остальными ошибками
+	files := []struct {
+		name string
+		path string
+	}{
+		{"CRYPTO_KEY", cfg.CryptoKey},
+		{"TLS_CERT", cfg.TLSCert},
+		{"TLS_KEY", cfg.TLSKey},
+		{"TLS_CLIENT_CA", cfg.TLSClientCA},
+		{"TOKENS_FILE", cfg.TokensFile},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+LoadServerConfig собирает конфигурацию сервера с приоритетом: флаги > переменные окружения >
+файл конфигурации (-c или CONFIG) > значения по умолчанию. Ошибки разбора окружения
+и проверки значений возвращаются вместе.
+*/
+func LoadServerConfig(fs *flag.FlagSet, args []string) (*ServerConfig, error) {
+	cfg := defaultServerConfig()
+
+	var configPath string
+	fs.StringVar(&configPath, "c", "", "CONFIG")
+	cfg.bindFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// флаги уже записаны в cfg, запоминаем их, чтобы применить поверх файла и окружения
+	setFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	if err := godotenv.Load(); err != nil {
+		log.Print(".env file not found")
+	}
+
+	if _, ok := setFlags["c"]; !ok {
+		lookupEnvString("CONFIG", &configPath)
+	}
+
+	fileCfg := defaultServerConfig()
+	if configPath != "" {
+		if err := loadConfigFile(configPath, fileCfg); err != nil {
+			return nil, err
+		}
+	}
+	*cfg = *fileCfg
+
+	envErr := initServerConfigENV(cfg)
+
+	for name, value := range setFlags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := errors.Join(envErr, cfg.validate()); err != nil {
+		return nil, err
+	}
+
+	cfg.IsDatabaseUsage = cfg.DatabaseDSN != ""
+	cfg.IsSyncSaving = cfg.StoreInterval == 0 && !cfg.IsDatabaseUsage
+
+	if cfg.IsDatabaseUsage {
+		cfg.Restore = false
+	}
+
+	return cfg, nil
+}
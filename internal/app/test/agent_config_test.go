@@ -0,0 +1,232 @@
+package app
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/app"
+)
+
+// agentEnv - переменные окружения, которые читает агент и задают тесты
+var agentEnv = []string{"CONFIG", "ADDRESS", "REPORT_INTERVAL", "POLL_INTERVAL", "KEY", "TLS", "TLS_CA", "TLS_CERT", "TLS_KEY", "EXEC_CONFIG"}
+
+// setEnv задаёт окружение теста, остальные переменные из names удаляются до его завершения
+func setEnv(t *testing.T, names []string, env map[string]string) {
+	t.Helper()
+
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadAgentConfig(t *testing.T, file string, env map[string]string, args ...string) (*app.AgentConfig, error) {
+	t.Helper()
+
+	if file != "" {
+		args = append([]string{"-c", writeFile(t, "agent.json", file)}, args...)
+	}
+	setEnv(t, agentEnv, env)
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return app.LoadAgentConfig(fs, args)
+}
+
+func Test_AgentConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name           string
+		file           string
+		env            map[string]string
+		args           []string
+		host           string
+		reportInterval int
+		pollInterval   int
+	}{
+		{
+			name:           "defaults",
+			host:           "localhost:8080",
+			reportInterval: 10,
+			pollInterval:   2,
+		},
+		{
+			name:           "file over defaults",
+			file:           `{"ADDRESS": "file:8080", "REPORT_INTERVAL": 20}`,
+			host:           "file:8080",
+			reportInterval: 20,
+			pollInterval:   2,
+		},
+		{
+			name:           "legacy file keys",
+			file:           `{"host": "legacy:8080", "report_interval": 30, "poll_interval": 3}`,
+			host:           "legacy:8080",
+			reportInterval: 30,
+			pollInterval:   3,
+		},
+		{
+			name:           "env over file",
+			file:           `{"ADDRESS": "file:8080", "REPORT_INTERVAL": 20, "POLL_INTERVAL": 4}`,
+			env:            map[string]string{"ADDRESS": "env:8080", "REPORT_INTERVAL": "40"},
+			host:           "env:8080",
+			reportInterval: 40,
+			pollInterval:   4,
+		},
+		{
+			name:           "flags over env and file",
+			file:           `{"ADDRESS": "file:8080", "POLL_INTERVAL": 4}`,
+			env:            map[string]string{"ADDRESS": "env:8080", "REPORT_INTERVAL": "40"},
+			args:           []string{"-a", "flag:8080", "-r", "50"},
+			host:           "flag:8080",
+			reportInterval: 50,
+			pollInterval:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadAgentConfig(t, tt.file, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Host != tt.host || cfg.ReportInterval != tt.reportInterval || cfg.PollInterval != tt.pollInterval {
+				t.Errorf("got host=%s report=%d poll=%d, want %s %d %d",
+					cfg.Host, cfg.ReportInterval, cfg.PollInterval, tt.host, tt.reportInterval, tt.pollInterval)
+			}
+		})
+	}
+}
+
+func Test_AgentConfigTLS(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want bool
+	}{
+		{"disabled by default", "", nil, nil, false},
+		{"enabled by CA", "", map[string]string{"TLS_CA": "ca.pem"}, nil, true},
+		{"explicit flag", "", map[string]string{"TLS_CA": "ca.pem"}, []string{"-tls=false"}, false},
+		{"explicit env", "", map[string]string{"TLS_CA": "ca.pem", "TLS": "false"}, nil, false},
+		{"explicit file", `{"TLS": false, "TLS_CA": "ca.pem"}`, nil, nil, false},
+		{"flag over env", "", map[string]string{"TLS": "false"}, []string{"-tls"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadAgentConfig(t, tt.file, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.TLS != tt.want {
+				t.Errorf("got TLS=%v, want %v", cfg.TLS, tt.want)
+			}
+		})
+	}
+}
+
+func Test_AgentConfigValidation(t *testing.T) {
+	execConfig := writeFile(t, "exec.json", `[{"name": "queue", "command": ["echo", "gauge queue 1"]}]`)
+
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		args  []string
+		wants []string
+	}{
+		{
+			name:  "invalid env integer",
+			env:   map[string]string{"REPORT_INTERVAL": "ten"},
+			wants: []string{`REPORT_INTERVAL: invalid integer "ten"`},
+		},
+		{
+			name:  "unknown file key",
+			file:  `{"REPORT_INTERVALS": 10}`,
+			wants: []string{`unknown field "REPORT_INTERVALS"`},
+		},
+		{
+			name:  "several errors at once",
+			args:  []string{"-r", "0", "-l", "0", "-compress-level", "10"},
+			wants: []string{"REPORT_INTERVAL must be positive", "RATE_LIMIT must be positive", "COMPRESS_LEVEL must be in 0..9"},
+		},
+		{
+			name:  "client certificate without key",
+			env:   map[string]string{"TLS_CERT": "cert.pem"},
+			wants: []string{"TLS_CERT and TLS_KEY must be set together"},
+		},
+		{
+			name:  "client key without certificate",
+			args:  []string{"-tls-key", "key.pem"},
+			wants: []string{"TLS_CERT and TLS_KEY must be set together"},
+		},
+		{
+			name:  "duplicate exec names",
+			file:  `{"EXEC_COMMANDS": [{"name": "queue", "command": ["true"]}]}`,
+			env:   map[string]string{"EXEC_CONFIG": execConfig},
+			wants: []string{"duplicate command name queue"},
+		},
+		{
+			name:  "fanout without outbox",
+			args:  []string{"-a", "one:8080,two:8080", "-servers-mode", "fanout"},
+			wants: []string{"requires OUTBOX_PATH"},
+		},
+		{
+			name:  "remote sidecar",
+			args:  []string{"-sidecar", "0.0.0.0:9125"},
+			wants: []string{"is not a loopback address"},
+		},
+		{
+			name:  "scrape without key",
+			args:  []string{"-scrape", "localhost:9100"},
+			wants: []string{"SCRAPE_ADDRESS requires KEY"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadAgentConfig(t, tt.file, tt.env, tt.args...)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, want := range tt.wants {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_AgentConfigRedacted(t *testing.T) {
+	cfg := app.AgentConfig{Key: "sign-key", Token: "bearer-token", Host: "localhost:8080"}
+
+	redacted := cfg.Redacted()
+	if redacted.Key != "***" || redacted.Token != "***" || redacted.Host != cfg.Host {
+		t.Errorf("got %+v", redacted)
+	}
+	if cfg.Key != "sign-key" || cfg.Token != "bearer-token" {
+		t.Error("Redacted modified the original config")
+	}
+
+	if empty := (app.AgentConfig{}).Redacted(); empty.Key != "" || empty.Token != "" {
+		t.Error("empty secrets must stay empty")
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/app"
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/outbox"
+	"go.uber.org/zap"
+)
+
+// stubSender отвечает на все пачки кодом status и считает вызовы
+type stubSender struct {
+	status int
+	calls  atomic.Int32
+}
+
+func (s *stubSender) SendMetricsPack(model.MetricsPack) error {
+	s.calls.Add(1)
+	if s.status == http.StatusOK {
+		return nil
+	}
+	return &handlers.StatusError{StatusCode: s.status, Status: http.StatusText(s.status)}
+}
+
+func newDelivery(t *testing.T, name string, sender app.PackSender, withOutbox bool) (*app.Delivery, *outbox.Outbox) {
+	t.Helper()
+
+	var box *outbox.Outbox
+	if withOutbox {
+		var err error
+		box, err = outbox.New(t.TempDir(), 0, 0, outbox.DropOldest)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app.NewDelivery(name, sender, box, zap.NewNop().Sugar()), box
+}
+
+func testPack() model.MetricsPack {
+	delta := int64(1)
+	return model.MetricsPack{{ID: "PollCount", MType: "counter", Delta: &delta}}
+}
+
+func Test_DeliverAllFanout(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	tests := []struct {
+		name     string
+		statuses []int
+		accepted bool
+		queued   []int
+	}{
+		{"all accept", []int{http.StatusOK, http.StatusOK}, true, []int{0, 0}},
+		{"one rejects", []int{http.StatusOK, http.StatusBadRequest}, true, []int{0, 0}},
+		{"one unavailable", []int{http.StatusOK, http.StatusServiceUnavailable}, true, []int{0, 1}},
+		{"all unavailable", []int{http.StatusServiceUnavailable, http.StatusBadGateway}, true, []int{1, 1}},
+		{"all reject", []int{http.StatusBadRequest, http.StatusUnauthorized}, false, []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deliveries []*app.Delivery
+			var boxes []*outbox.Outbox
+			for i, status := range tt.statuses {
+				d, box := newDelivery(t, fmt.Sprintf("server%d", i), &stubSender{status: status}, true)
+				deliveries = append(deliveries, d)
+				boxes = append(boxes, box)
+			}
+
+			if got := app.DeliverAll(deliveries, testPack(), logger); got != tt.accepted {
+				t.Errorf("got accepted=%v, want %v", got, tt.accepted)
+			}
+			for i, box := range boxes {
+				if box.Len() != tt.queued[i] {
+					t.Errorf("server %d: got %d queued packs, want %d", i, box.Len(), tt.queued[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_SendOrQueueKeepsOrder(t *testing.T) {
+	sender := &stubSender{status: http.StatusServiceUnavailable}
+	d, box := newDelivery(t, "server", sender, true)
+
+	if !d.SendOrQueue(testPack()) {
+		t.Fatal("pack is not queued")
+	}
+
+	// пока очередь не пуста, новые пачки встают в её конец без попытки отправки
+	sender.status = http.StatusOK
+	if !d.SendOrQueue(testPack()) {
+		t.Fatal("pack is not queued")
+	}
+	if sender.calls.Load() != 1 || box.Len() != 2 {
+		t.Errorf("got %d sends and %d queued packs, want 1 and 2", sender.calls.Load(), box.Len())
+	}
+}
+
+func Test_SendOrQueueWithoutOutbox(t *testing.T) {
+	d, _ := newDelivery(t, "server", &stubSender{status: http.StatusServiceUnavailable}, false)
+
+	if d.SendOrQueue(testPack()) {
+		t.Error("pack is accepted by unavailable server without outbox")
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/app"
+)
+
+// serverEnv - переменные окружения, которые читает сервер и задают тесты
+var serverEnv = []string{"CONFIG", "ADDRESS", "STORE_INTERVAL", "FILE_STORAGE_PATH", "RESTORE", "DATABASE_DSN", "KEY", "REPLAY_WINDOW", "CRYPTO_KEY", "TLS_CERT", "TLS_KEY", "SCRAPE_TARGETS", "HISTORY_LIMIT", "LEGACY_BODY_SIGN"}
+
+func loadServerConfig(t *testing.T, file string, env map[string]string, args ...string) (*app.ServerConfig, error) {
+	t.Helper()
+
+	if file != "" {
+		args = append([]string{"-c", writeFile(t, "server.json", file)}, args...)
+	}
+	setEnv(t, serverEnv, env)
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return app.LoadServerConfig(fs, args)
+}
+
+func Test_ServerConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		env           map[string]string
+		args          []string
+		host          string
+		storeInterval int64
+		restore       bool
+		syncSaving    bool
+		databaseUsage bool
+	}{
+		{
+			name:          "defaults",
+			host:          "localhost:8080",
+			storeInterval: 300,
+			restore:       true,
+		},
+		{
+			name:          "file over defaults",
+			file:          `{"HOST": "file:8080", "STORE_INTERVAL": 0, "RESTORE": false}`,
+			host:          "file:8080",
+			storeInterval: 0,
+			syncSaving:    true,
+		},
+		{
+			name:          "env over file",
+			file:          `{"HOST": "file:8080", "STORE_INTERVAL": 0, "RESTORE": false}`,
+			env:           map[string]string{"ADDRESS": "env:8080", "STORE_INTERVAL": "5"},
+			host:          "env:8080",
+			storeInterval: 5,
+		},
+		{
+			name:          "flags over env and file",
+			file:          `{"HOST": "file:8080", "STORE_INTERVAL": 0}`,
+			env:           map[string]string{"ADDRESS": "env:8080", "STORE_INTERVAL": "5", "RESTORE": "false"},
+			args:          []string{"-a", "flag:8080", "-i", "7", "-r=true"},
+			host:          "flag:8080",
+			storeInterval: 7,
+			restore:       true,
+		},
+		{
+			name:          "database disables restore and sync saving",
+			file:          `{"DATABASE_DSN": "postgres://localhost/metrics", "STORE_INTERVAL": 0}`,
+			host:          "localhost:8080",
+			storeInterval: 0,
+			databaseUsage: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadServerConfig(t, tt.file, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Host != tt.host || cfg.StoreInterval != tt.storeInterval || cfg.Restore != tt.restore {
+				t.Errorf("got host=%s store=%d restore=%v, want %s %d %v",
+					cfg.Host, cfg.StoreInterval, cfg.Restore, tt.host, tt.storeInterval, tt.restore)
+			}
+			if cfg.IsSyncSaving != tt.syncSaving || cfg.IsDatabaseUsage != tt.databaseUsage {
+				t.Errorf("got sync=%v database=%v, want %v %v", cfg.IsSyncSaving, cfg.IsDatabaseUsage, tt.syncSaving, tt.databaseUsage)
+			}
+		})
+	}
+}
+
+func Test_ServerConfigValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		args  []string
+		wants []string
+	}{
+		{
+			name: "env and validation errors at once",
+			env:  map[string]string{"STORE_INTERVAL": "5m", "RESTORE": "yes"},
+			args: []string{"-replay-window", "-1", "-history-limit", "-1", "-tls-cert", "cert.pem", "-crypto-key", "missing.pem"},
+			wants: []string{
+				`STORE_INTERVAL: invalid integer "5m"`,
+				`RESTORE: invalid boolean "yes"`,
+				"REPLAY_WINDOW must not be negative",
+				"HISTORY_LIMIT must not be negative",
+				"TLS_CERT and TLS_KEY must be set together",
+				"CRYPTO_KEY: stat missing.pem",
+			},
+		},
+		{
+			name:  "derived fields in file",
+			file:  `{"DBUsage": true}`,
+			wants: []string{"DBUsage and SyncSaving are derived"},
+		},
+		{
+			name:  "unknown file key",
+			file:  `{"STORE_INTERVALS": 10}`,
+			wants: []string{`unknown field "STORE_INTERVALS"`},
+		},
+		{
+			name:  "legacy signatures with replay protection",
+			env:   map[string]string{"LEGACY_BODY_SIGN": "true"},
+			wants: []string{"LEGACY_BODY_SIGN requires REPLAY_WINDOW=0"},
+		},
+		{
+			name:  "scrape without key",
+			args:  []string{"-scrape-targets", "agent:9100"},
+			wants: []string{"SCRAPE_TARGETS requires KEY"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadServerConfig(t, tt.file, tt.env, tt.args...)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, want := range tt.wants {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_ServerConfigRedacted(t *testing.T) {
+	tests := []struct {
+		name   string
+		dsn    string
+		want   string
+		secret string
+	}{
+		{name: "url dsn", dsn: "postgres://metrics:s3cret@db:5432/metrics", want: "postgres://metrics:xxxxx@db:5432/metrics", secret: "s3cret"},
+		{name: "key value dsn", dsn: "host=db user=metrics password=s3cret dbname=metrics", want: "host=db user=metrics password=*** dbname=metrics", secret: "s3cret"},
+		{name: "dsn without password", dsn: "postgres://metrics@db/metrics", want: "postgres://metrics@db/metrics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := app.ServerConfig{Key: "sign-key", DatabaseDSN: tt.dsn}
+			redacted := cfg.Redacted()
+
+			if redacted.Key != "***" || redacted.DatabaseDSN != tt.want {
+				t.Errorf("got key=%q dsn=%q, want *** and %q", redacted.Key, redacted.DatabaseDSN, tt.want)
+			}
+			if tt.secret != "" && strings.Contains(redacted.DatabaseDSN, tt.secret) {
+				t.Errorf("password leaked in %q", redacted.DatabaseDSN)
+			}
+			if cfg.Key != "sign-key" || cfg.DatabaseDSN != tt.dsn {
+				t.Error("Redacted modified the original config")
+			}
+		})
+	}
+}
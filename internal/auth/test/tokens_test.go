@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/auth"
+)
+
+func writeRegistry(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_NewTokenRegistryMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{name: "not json", data: `{"name":`},
+		{name: "not a list", data: `{"name": "agent", "token": "t1"}`},
+		{name: "empty token", data: `[{"name": "agent", "token": "", "scopes": ["read:metrics"]}]`, wantErr: auth.ErrorEmptyToken},
+		{name: "unknown scope", data: `[{"name": "agent", "token": "t1", "scopes": ["write:all"]}]`, wantErr: auth.ErrorUnknownScope},
+		{name: "duplicate token", data: `[{"name": "a", "token": "t1"}, {"name": "b", "token": "t1"}]`, wantErr: auth.ErrorDuplicateToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			writeRegistry(t, path, test.data)
+
+			_, err := auth.NewTokenRegistry(path)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := auth.NewTokenRegistry(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func Test_TokenRegistryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	writeRegistry(t, path, `[{"name": "agent", "token": "t1", "scopes": ["write:metrics"]}]`)
+
+	registry, err := auth.NewTokenRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, ok := registry.Lookup("t1")
+	if !ok || !token.HasScope(auth.ScopeWriteMetrics) || token.HasScope(auth.ScopeReadMetrics) {
+		t.Fatalf("unexpected token %+v, found %v", token, ok)
+	}
+
+	// ошибка в файле не сбрасывает действующие токены
+	writeRegistry(t, path, `[{"name": "agent"`)
+	if err := registry.Reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if _, ok := registry.Lookup("t1"); !ok {
+		t.Error("token t1 lost after failed reload")
+	}
+
+	// перечитывание по сигналу
+	writeRegistry(t, path, `[{"name": "dashboard", "token": "t2", "scopes": ["admin"]}]`)
+
+	signals := make(chan os.Signal, 1)
+	results := make(chan error, 1)
+	go registry.ReloadOn(signals, func(err error) { results <- err })
+
+	signals <- syscall.SIGHUP
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	close(signals)
+
+	if _, ok := registry.Lookup("t1"); ok {
+		t.Error("token t1 still valid after reload")
+	}
+	token, ok = registry.Lookup("t2")
+	if !ok || !token.HasScope(auth.ScopeReadMetrics) {
+		t.Errorf("admin token t2: got %+v, found %v", token, ok)
+	}
+}
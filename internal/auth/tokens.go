@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	ScopeReadMetrics  = "read:metrics"
+	ScopeWriteMetrics = "write:metrics"
+	ScopeAdmin        = "admin" // включает все остальные права
+)
+
+var (
+	ErrorEmptyToken     = errors.New("empty token")
+	ErrorDuplicateToken = errors.New("duplicate token")
+	ErrorUnknownScope   = errors.New("unknown scope")
+)
+
+// Token - запись реестра: имя владельца (агента, дашборда), секрет и список прав
+type Token struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+TokenRegistry хранит токены доступа из JSON файла вида
+[{"name": "agent-01", "token": "...", "scopes": ["write:metrics"]}]
+Файл можно перечитать без перезапуска сервера методом Reload.
+*/
+type TokenRegistry struct {
+	path string
+
+	mx     sync.RWMutex
+	tokens map[[sha256.Size]byte]Token
+}
+
+func NewTokenRegistry(path string) (*TokenRegistry, error) {
+	registry := &TokenRegistry{path: path}
+	if err := registry.Reload(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Reload перечитывает файл, при ошибке продолжает действовать прежний набор токенов
+func (r *TokenRegistry) Reload() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	var list []Token
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", r.path, err)
+	}
+
+	tokens := make(map[[sha256.Size]byte]Token, len(list))
+	for _, t := range list {
+		if t.Token == "" {
+			return fmt.Errorf("%s: %w for %q", r.path, ErrorEmptyToken, t.Name)
+		}
+		for _, scope := range t.Scopes {
+			if scope != ScopeReadMetrics && scope != ScopeWriteMetrics && scope != ScopeAdmin {
+				return fmt.Errorf("%s: %w %q for %q", r.path, ErrorUnknownScope, scope, t.Name)
+			}
+		}
+
+		// в памяти держим только хэши, поиск по ним не зависит от совпадения префикса секрета
+		key := sha256.Sum256([]byte(t.Token))
+		if _, ok := tokens[key]; ok {
+			return fmt.Errorf("%s: %w for %q", r.path, ErrorDuplicateToken, t.Name)
+		}
+		t.Token = ""
+		tokens[key] = t
+	}
+
+	r.mx.Lock()
+	r.tokens = tokens
+	r.mx.Unlock()
+
+	return nil
+}
+
+// ReloadOn перечитывает реестр на каждый сигнал из signals (обычно SIGHUP) до закрытия канала,
+// результат каждой попытки передаётся в onReload
+func (r *TokenRegistry) ReloadOn(signals <-chan os.Signal, onReload func(error)) {
+	for range signals {
+		onReload(r.Reload())
+	}
+}
+
+func (r *TokenRegistry) Lookup(token string) (Token, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	t, ok := r.tokens[sha256.Sum256([]byte(token))]
+	return t, ok
+}
+
+func (r *TokenRegistry) Len() int {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return len(r.tokens)
+}
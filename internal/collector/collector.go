@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrorUnknownCollector   = errors.New("unknown collector")
+	ErrorDuplicateCollector = errors.New("collector is already registered")
+	ErrorUnsupported        = errors.New("collector is not supported on this system")
+)
+
+// Collector - источник метрик агента, опрашиваемый с собственным интервалом
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) (model.MetricsPack, error)
+}
+
+// Factory создаёт коллектор с заданным интервалом опроса.
+// Если коллектор не может работать в текущей системе, фабрика возвращает ErrorUnsupported
+type Factory func(interval time.Duration) (Collector, error)
+
+// Sink принимает собранные метрики, в агенте это MetricService.AddMetricsPack
+type Sink func(metrics model.MetricsPack) error
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+/*
+Register добавляет фабрику коллектора в реестр под именем name.
+Вызывается из init() пакета с коллектором, после чего коллектор можно включить в конфиге агента.
+*/
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("%v: %s", ErrorDuplicateCollector, name))
+	}
+	factories[name] = factory
+}
+
+// Registered возвращает отсортированный список имён зарегистрированных коллекторов
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New создаёт зарегистрированный коллектор по имени
+func New(name string, interval time.Duration) (Collector, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownCollector, name)
+	}
+	return factory(interval)
+}
+
+/*
+Run опрашивает каждый коллектор в отдельной горутине по его интервалу и передаёт метрики в sink.
+Ошибка или паника одного коллектора не влияет на остальные. Сбор ограничен интервалом коллектора.
+Возвращает управление после отмены ctx и завершения всех горутин.
+*/
+func Run(ctx context.Context, collectors []Collector, sink Sink, logger *zap.SugaredLogger) {
+	var wg sync.WaitGroup
+
+	for _, c := range collectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(c.Interval())
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					collectOnce(ctx, c, sink, logger)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func collectOnce(ctx context.Context, c Collector, sink Sink, logger *zap.SugaredLogger) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("collector %s panic: %v", c.Name(), r)
+		}
+	}()
+
+	collectCtx, cancel := context.WithTimeout(ctx, c.Interval())
+	defer cancel()
+
+	metrics, err := c.Collect(collectCtx)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			logger.Debugf("collector %s stopped: %v", c.Name(), err)
+			return
+		}
+		logger.Errorf("collector %s error: %v", c.Name(), err)
+		return
+	}
+
+	if len(metrics) == 0 {
+		return
+	}
+
+	err = sink(metrics)
+	if err != nil {
+		logger.Errorf("collector %s metrics saving error: %v", c.Name(), err)
+	}
+}
+
+func gaugeMetric(id string, value float64) model.Metric {
+	return model.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func counterMetric(id string, delta int64) model.Metric {
+	return model.Metric{ID: id, MType: "counter", Delta: &delta}
+}
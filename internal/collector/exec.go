@@ -0,0 +1,193 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+var (
+	ErrorExecConfig    = errors.New("invalid exec collector config")
+	ErrorExecOutput    = errors.New("invalid exec collector output")
+	ErrorExecTimeout   = errors.New("command timed out")
+	ErrorExecCancelled = errors.New("command cancelled")
+)
+
+// execWaitDelay - сколько ждать закрытия stdout после завершения команды по таймауту
+const execWaitDelay = time.Second
+
+// ExecCommand - описание внешней команды в файле EXEC_CONFIG
+type ExecCommand struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`  // путь к программе и аргументы, без оболочки
+	Interval int      `json:"interval"` // секунды, 0 - интервал опроса агента
+	Timeout  int      `json:"timeout"`  // секунды, 0 - равен интервалу; не может превышать интервал
+}
+
+/*
+ExecCollector периодически запускает внешнюю команду и разбирает её stdout как метрики.
+Поддерживаются строки вида "gauge name 1.5" / "counter name 3" (пустые строки и "#" пропускаются)
+либо JSON массив в формате model.MetricsPack.
+*/
+type ExecCollector struct {
+	name     string
+	command  []string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func NewExecCollector(cmd ExecCommand, defInterval time.Duration) (*ExecCollector, error) {
+	if cmd.Name == "" || len(cmd.Command) == 0 {
+		return nil, fmt.Errorf("%w: name and command are required", ErrorExecConfig)
+	}
+	if cmd.Interval < 0 || cmd.Timeout < 0 {
+		return nil, fmt.Errorf("%w: %s: negative interval or timeout", ErrorExecConfig, cmd.Name)
+	}
+
+	interval := defInterval
+	if cmd.Interval > 0 {
+		interval = time.Duration(cmd.Interval) * time.Second
+	}
+
+	timeout := interval
+	if cmd.Timeout > 0 {
+		timeout = time.Duration(cmd.Timeout) * time.Second
+	}
+	// следующий запуск не должен начинаться, пока предыдущий ещё может выполняться
+	if timeout > interval {
+		return nil, fmt.Errorf("%w: %s: timeout %s exceeds interval %s", ErrorExecConfig, cmd.Name, timeout, interval)
+	}
+
+	return &ExecCollector{
+		name:     "exec:" + cmd.Name,
+		command:  cmd.Command,
+		interval: interval,
+		timeout:  timeout,
+	}, nil
+}
+
+// LoadExecCommands читает JSON файл со списком команд для NewExecCollectors
+func LoadExecCommands(path string) ([]ExecCommand, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []ExecCommand
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrorExecConfig, path, err)
+	}
+	return commands, nil
+}
+
+// NewExecCollectors создаёт коллекторы для списка команд, имена команд должны быть уникальны
+func NewExecCollectors(commands []ExecCommand, defInterval time.Duration) ([]Collector, error) {
+	names := make(map[string]bool, len(commands))
+	collectors := make([]Collector, 0, len(commands))
+	for _, cmd := range commands {
+		if names[cmd.Name] {
+			return nil, fmt.Errorf("%w: duplicate command name %s", ErrorExecConfig, cmd.Name)
+		}
+		names[cmd.Name] = true
+
+		c, err := NewExecCollector(cmd, defInterval)
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, c)
+	}
+
+	return collectors, nil
+}
+
+func (c *ExecCollector) Name() string {
+	return c.name
+}
+
+func (c *ExecCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *ExecCollector) Collect(ctx context.Context) (model.MetricsPack, error) {
+	runCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, c.command[0], c.command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = execWaitDelay
+
+	err := cmd.Run()
+	// остановка агента отменяет родительский контекст, это не таймаут команды
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, fmt.Errorf("%w: %w", ErrorExecCancelled, ctx.Err())
+	}
+	if runCtx.Err() != nil {
+		return nil, fmt.Errorf("%w after %s", ErrorExecTimeout, c.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return ParseExecOutput(stdout.Bytes())
+}
+
+// ParseExecOutput разбирает вывод команды: JSON model.MetricsPack или строки "type name value"
+func ParseExecOutput(data []byte) (model.MetricsPack, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' {
+		var metrics model.MetricsPack
+		if err := json.Unmarshal(trimmed, &metrics); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrorExecOutput, err)
+		}
+		return metrics, nil
+	}
+
+	var result model.MetricsPack
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: line %d: want \"type name value\": %q", ErrorExecOutput, lineNum, line)
+		}
+
+		switch fields[0] {
+		case "gauge":
+			value, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %q", ErrorExecOutput, lineNum, line)
+			}
+			result = append(result, gaugeMetric(fields[1], value))
+		case "counter":
+			delta, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %q", ErrorExecOutput, lineNum, line)
+			}
+			result = append(result, counterMetric(fields[1], delta))
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown type %q", ErrorExecOutput, lineNum, fields[0])
+		}
+	}
+
+	return result, scanner.Err()
+}
@@ -0,0 +1,201 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+const defProcPath = "/proc"
+
+var ErrorProcFormat = errors.New("unexpected /proc file format")
+
+func init() {
+	Register("host", func(interval time.Duration) (Collector, error) {
+		// /proc есть только в Linux, на других системах коллектор не создаётся
+		if _, err := os.Stat(filepath.Join(defProcPath, "stat")); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrorUnsupported, err)
+		}
+		return NewHostCollector(defProcPath, interval), nil
+	})
+}
+
+// cpuTimes - счётчики времени одного процессора из /proc/stat в jiffies
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+/*
+HostCollector читает метрики хоста из /proc: память (/proc/meminfo),
+загрузку каждого процессора (/proc/stat) и среднюю нагрузку (/proc/loadavg).
+Загрузка процессоров считается между двумя последовательными вызовами Collect,
+при первом вызове - в среднем с момента загрузки системы.
+*/
+type HostCollector struct {
+	procPath string
+	interval time.Duration
+	prevCPU  []cpuTimes
+}
+
+func NewHostCollector(procPath string, interval time.Duration) *HostCollector {
+	return &HostCollector{procPath: procPath, interval: interval}
+}
+
+func (c *HostCollector) Name() string {
+	return "host"
+}
+
+func (c *HostCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *HostCollector) Collect(_ context.Context) (model.MetricsPack, error) {
+	var result model.MetricsPack
+
+	memory, err := c.readMemInfo()
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, memory...)
+
+	cpu, err := c.readCPUUtilization()
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, cpu...)
+
+	load, err := c.readLoadAvg()
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, load...)
+
+	return result, nil
+}
+
+func (c *HostCollector) readMemInfo() (model.MetricsPack, error) {
+	file, err := os.Open(filepath.Join(c.procPath, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	names := map[string]string{
+		"MemTotal":     "TotalMemory",
+		"MemFree":      "FreeMemory",
+		"MemAvailable": "AvailableMemory",
+	}
+
+	var result model.MetricsPack
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// MemTotal:       16303412 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		name, ok := names[strings.TrimSuffix(fields[0], ":")]
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: meminfo %q", ErrorProcFormat, scanner.Text())
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= 1024
+		}
+
+		result = append(result, gaugeMetric(name, float64(value)))
+	}
+
+	return result, scanner.Err()
+}
+
+func (c *HostCollector) readCPUUtilization() (model.MetricsPack, error) {
+	file, err := os.Open(filepath.Join(c.procPath, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var current []cpuTimes
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// cpu0 user nice system idle iowait irq softirq steal guest guest_nice
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+
+		var times cpuTimes
+		// guest и guest_nice уже учтены в user и nice
+		for i, raw := range fields[1:min(len(fields), 9)] {
+			value, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: stat %q", ErrorProcFormat, scanner.Text())
+			}
+			times.total += value
+			if i == 3 || i == 4 { // idle, iowait
+				times.idle += value
+			}
+		}
+		current = append(current, times)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make(model.MetricsPack, 0, len(current))
+	for i, times := range current {
+		var prev cpuTimes
+		if i < len(c.prevCPU) {
+			prev = c.prevCPU[i]
+		}
+
+		var utilization float64
+		if totalDelta := times.total - prev.total; times.total > prev.total && times.idle >= prev.idle {
+			utilization = 100 * (1 - float64(times.idle-prev.idle)/float64(totalDelta))
+		}
+		result = append(result, gaugeMetric(fmt.Sprintf("CPUutilization%d", i+1), utilization))
+	}
+	c.prevCPU = current
+
+	return result, nil
+}
+
+func (c *HostCollector) readLoadAvg() (model.MetricsPack, error) {
+	data, err := os.ReadFile(filepath.Join(c.procPath, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+
+	// 0.52 0.58 0.59 1/1234 5678
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("%w: loadavg %q", ErrorProcFormat, data)
+	}
+
+	names := []string{"LoadAverage1", "LoadAverage5", "LoadAverage15"}
+	result := make(model.MetricsPack, 0, len(names))
+	for i, name := range names {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: loadavg %q", ErrorProcFormat, data)
+		}
+		result = append(result, gaugeMetric(name, value))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+func init() {
+	Register("runtime", func(interval time.Duration) (Collector, error) {
+		return NewRuntimeCollector(interval), nil
+	})
+}
+
+// RuntimeCollector собирает статистику памяти Go рантайма, RandomValue и счётчик опросов PollCount
+type RuntimeCollector struct {
+	interval time.Duration
+	memStat  runtime.MemStats
+}
+
+func NewRuntimeCollector(interval time.Duration) *RuntimeCollector {
+	return &RuntimeCollector{interval: interval}
+}
+
+func (c *RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c *RuntimeCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *RuntimeCollector) Collect(_ context.Context) (model.MetricsPack, error) {
+	runtime.ReadMemStats(&c.memStat)
+	m := &c.memStat
+
+	result := model.MetricsPack{
+		gaugeMetric("Alloc", float64(m.Alloc)),
+		gaugeMetric("BuckHashSys", float64(m.BuckHashSys)),
+		gaugeMetric("Frees", float64(m.Frees)),
+		gaugeMetric("GCCPUFraction", m.GCCPUFraction),
+		gaugeMetric("GCSys", float64(m.GCSys)),
+		gaugeMetric("HeapAlloc", float64(m.HeapAlloc)),
+		gaugeMetric("HeapIdle", float64(m.HeapIdle)),
+		gaugeMetric("HeapInuse", float64(m.HeapInuse)),
+		gaugeMetric("HeapObjects", float64(m.HeapObjects)),
+		gaugeMetric("HeapReleased", float64(m.HeapReleased)),
+		gaugeMetric("HeapSys", float64(m.HeapSys)),
+		gaugeMetric("LastGC", float64(m.LastGC)),
+		gaugeMetric("Lookups", float64(m.Lookups)),
+		gaugeMetric("MCacheInuse", float64(m.MCacheInuse)),
+		gaugeMetric("MCacheSys", float64(m.MCacheSys)),
+		gaugeMetric("MSpanInuse", float64(m.MSpanInuse)),
+		gaugeMetric("MSpanSys", float64(m.MSpanSys)),
+		gaugeMetric("Mallocs", float64(m.Mallocs)),
+		gaugeMetric("NextGC", float64(m.NextGC)),
+		gaugeMetric("NumForcedGC", float64(m.NumForcedGC)),
+		gaugeMetric("NumGC", float64(m.NumGC)),
+		gaugeMetric("OtherSys", float64(m.OtherSys)),
+		gaugeMetric("PauseTotalNs", float64(m.PauseTotalNs)),
+		gaugeMetric("StackInuse", float64(m.StackInuse)),
+		gaugeMetric("StackSys", float64(m.StackSys)),
+		gaugeMetric("Sys", float64(m.Sys)),
+		gaugeMetric("TotalAlloc", float64(m.TotalAlloc)),
+		gaugeMetric("RandomValue", float64(rand.Intn(100))),
+		counterMetric("PollCount", 1),
+	}
+
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/collector"
+	"github.com/bbquite/mca-server/internal/model"
+	"go.uber.org/zap"
+)
+
+type stubCollector struct {
+	name string
+	fail bool
+}
+
+func (c *stubCollector) Name() string { return c.name }
+
+func (c *stubCollector) Interval() time.Duration { return 10 * time.Millisecond }
+
+func (c *stubCollector) Collect(_ context.Context) (model.MetricsPack, error) {
+	if c.fail {
+		panic(errors.New("broken collector"))
+	}
+	value := 1.0
+	return model.MetricsPack{{ID: c.name, MType: "gauge", Value: &value}}, nil
+}
+
+func Test_RunIsolatesFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+
+	sink := func(metrics model.MetricsPack) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range metrics {
+			received[m.ID]++
+		}
+		return nil
+	}
+
+	collectors := []collector.Collector{
+		&stubCollector{name: "broken", fail: true},
+		&stubCollector{name: "ok"},
+	}
+	collector.Run(ctx, collectors, sink, zap.NewNop().Sugar())
+
+	if received["ok"] < 2 {
+		t.Errorf("healthy collector was polled %d times, want at least 2", received["ok"])
+	}
+	if received["broken"] != 0 {
+		t.Errorf("broken collector delivered metrics")
+	}
+}
+
+func Test_NewUnknownCollector(t *testing.T) {
+	_, err := collector.New("unknown", time.Second)
+	if !errors.Is(err, collector.ErrorUnknownCollector) {
+		t.Errorf("got %v, want %v", err, collector.ErrorUnknownCollector)
+	}
+}
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/collector"
+)
+
+func Test_ParseExecOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{name: "lines", output: "# disk checks\ngauge DiskFree 12.5\n\ncounter Checks 2\n", want: 2},
+		{name: "json", output: `[{"id":"DiskFree","type":"gauge","value":12.5},{"id":"Checks","type":"counter","delta":2}]`, want: 2},
+		{name: "empty", output: "  \n", want: 0},
+		{name: "unknown type", output: "histogram x 1", wantErr: true},
+		{name: "bad counter", output: "counter x 1.5", wantErr: true},
+		{name: "missing value", output: "gauge x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := collector.ParseExecOutput([]byte(tt.output))
+			if tt.wantErr {
+				if !errors.Is(err, collector.ErrorExecOutput) {
+					t.Errorf("got error %v, want %v", err, collector.ErrorExecOutput)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(metrics) != tt.want {
+				t.Errorf("got %d metrics, want %d", len(metrics), tt.want)
+			}
+		})
+	}
+}
+
+func Test_NewExecCollectorTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     collector.ExecCommand
+		wantErr bool
+	}{
+		{name: "timeout equals interval", cmd: collector.ExecCommand{Name: "a", Command: []string{"true"}, Interval: 5, Timeout: 5}},
+		{name: "timeout below default interval", cmd: collector.ExecCommand{Name: "a", Command: []string{"true"}, Timeout: 1}},
+		{name: "timeout above interval", cmd: collector.ExecCommand{Name: "a", Command: []string{"true"}, Interval: 5, Timeout: 10}, wantErr: true},
+		{name: "timeout above default interval", cmd: collector.ExecCommand{Name: "a", Command: []string{"true"}, Timeout: 3}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := collector.NewExecCollector(test.cmd, 2*time.Second)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if err != nil && !errors.Is(err, collector.ErrorExecConfig) {
+				t.Errorf("got %v, want %v", err, collector.ErrorExecConfig)
+			}
+		})
+	}
+}
+
+func Test_ExecCollectorCancel(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		c, err := collector.NewExecCollector(collector.ExecCommand{Name: "slow", Command: []string{"sleep", "5"}, Interval: 1}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.Collect(context.Background())
+		if !errors.Is(err, collector.ErrorExecTimeout) {
+			t.Errorf("got %v, want %v", err, collector.ErrorExecTimeout)
+		}
+	})
+
+	t.Run("parent cancelled", func(t *testing.T) {
+		c, err := collector.NewExecCollector(collector.ExecCommand{Name: "slow", Command: []string{"sleep", "5"}, Interval: 5}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		_, err = c.Collect(ctx)
+		if !errors.Is(err, collector.ErrorExecCancelled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, collector.ErrorExecCancelled)
+		}
+	})
+}
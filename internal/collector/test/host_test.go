@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/collector"
+)
+
+func writeProcFile(t *testing.T, dir string, name string, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_HostCollector(t *testing.T) {
+	dir := t.TempDir()
+
+	writeProcFile(t, dir, "meminfo", "MemTotal:       2048 kB\nMemFree:         512 kB\nMemAvailable:   1024 kB\nBuffers:          10 kB\n")
+	writeProcFile(t, dir, "loadavg", "0.50 0.25 0.10 1/100 1234\n")
+	writeProcFile(t, dir, "stat", "cpu  200 0 0 200 0 0 0 0 0 0\ncpu0 100 0 0 100 0 0 0 0 0 0\ncpu1 100 0 0 100 0 0 0 0 0 0\nintr 1\n")
+
+	hostCollector := collector.NewHostCollector(dir, time.Second)
+	if _, err := hostCollector.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// cpu0: занят весь интервал, cpu1: простаивал весь интервал
+	writeProcFile(t, dir, "stat", "cpu  300 0 0 300 0 0 0 0 0 0\ncpu0 200 0 0 100 0 0 0 0 0 0\ncpu1 100 0 0 200 0 0 0 0 0 0\nintr 1\n")
+
+	metrics, err := hostCollector.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"TotalMemory":     2048 * 1024,
+		"FreeMemory":      512 * 1024,
+		"AvailableMemory": 1024 * 1024,
+		"CPUutilization1": 100,
+		"CPUutilization2": 0,
+		"LoadAverage1":    0.5,
+		"LoadAverage5":    0.25,
+		"LoadAverage15":   0.1,
+	}
+
+	if len(metrics) != len(want) {
+		t.Fatalf("got %d metrics, want %d", len(metrics), len(want))
+	}
+	for _, m := range metrics {
+		value, ok := want[m.ID]
+		if !ok {
+			t.Errorf("unexpected metric %s", m.ID)
+			continue
+		}
+		if m.MType != "gauge" || *m.Value != value {
+			t.Errorf("%s = %s %v, want gauge %v", m.ID, m.MType, *m.Value, value)
+		}
+	}
+}
@@ -2,165 +2,371 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/bbquite/mca-server/internal/middleware"
+	"github.com/bbquite/mca-server/internal/model"
 	"github.com/bbquite/mca-server/internal/service"
 	"github.com/bbquite/mca-server/internal/utils"
+	"github.com/bbquite/mca-server/pkg/xretry"
 	"go.uber.org/zap"
 )
 
-func SendMetricsURI(services *service.MetricService, host string, logger *zap.SugaredLogger) error {
+var ErrorUnexpectedStatus = errors.New("unexpected response status")
 
-	var url string
-	var value any
-	client := http.Client{}
+// maxRetryAfter ограничивает задержку, которую сервер может запросить заголовком Retry-After
+const maxRetryAfter = 30 * time.Second
 
-	metricsPack, err := services.GetAllMetrics()
-	if err != nil {
-		return err
+// StatusError - ответ сервера с кодом не из диапазона 2xx
+type StatusError struct {
+	StatusCode int
+	Status     string
+	retryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrorUnexpectedStatus, e.Status)
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrorUnexpectedStatus
+}
+
+// RetryAfter возвращает задержку из заголовка Retry-After, см. xretry.RetryAfterError
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// parseRetryAfter разбирает Retry-After в виде числа секунд или HTTP даты
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
 	}
 
-	logger.Infof("Sending metrics to %s", host)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
 
-	err = services.ResetCounterItem("PollCount")
-	if err != nil {
-		logger.Errorf("PollCount reset error")
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
 	}
 
-	for _, el := range metricsPack {
-		switch el.MType {
-		case "gauge":
-			value = fmt.Sprintf("%.2f", *el.Value)
-		case "counter":
-			value = fmt.Sprintf("%v", *el.Delta)
+	return 0
+}
+
+/*
+IsRetryable сообщает, имеет ли смысл повторить отправку: повторяются сетевые ошибки
+и ответы 5xx или 429. Ошибки подготовки запроса и остальные коды ответа не повторяются.
+Объединённая ошибка (errors.Join, например от FailoverSender) временная, только если временные все её части
+*/
+func IsRetryable(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !IsRetryable(e) {
+				return false
+			}
 		}
+		return len(errs) > 0
+	}
 
-		url = fmt.Sprintf("http://%s/update/%s/%s/%s", host, el.MType, el.ID, value)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
 
-		logger.Debugf("SEND %s", url)
+	// ошибки TLS приходят как *url.Error (net.Error), но повтор с тем же сертификатом не поможет
+	var (
+		certErr      *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &certErr) || errors.As(err, &alertErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return false
+	}
 
-		request, err := http.NewRequest(http.MethodPost, url, http.NoBody)
-		if err != nil {
-			logger.Error(err)
-			return nil
-		}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
 
-		request.Header.Set("Content-Type", "Content-Type: text/plain")
+// MetricsSender отправляет метрики агента на сервер
+type MetricsSender struct {
+	ctx       context.Context // при отмене прерываются запросы и ожидание повторов
+	services  *service.MetricService
+	host      string
+	scheme    string
+	shaKey    string
+	token     string
+	publicKey *rsa.PublicKey
+	realIP    string
+	client    *http.Client
+	retrier   *xretry.Retrier
+	logger    *zap.SugaredLogger
+
+	compressMinSize int
+	compressLevel   int
+}
 
-		response, err := client.Do(request)
-		if err != nil {
-			logger.Error(err)
-			return nil
-		}
+// SenderConfig - параметры подключения агента к серверу
+type SenderConfig struct {
+	Host      string
+	ShaKey    string
+	Token     string         // bearer токен из реестра сервера
+	PublicKey *rsa.PublicKey // nil отключает шифрование тела запросов
+	TLSConfig *tls.Config    // nil - отправка по http без TLS
 
-		err = response.Body.Close()
-		if err != nil {
-			logger.Error(err)
-			return nil
-		}
+	CompressMinSize int // тела меньшего размера отправляются без сжатия
+	CompressLevel   int // уровень gzip, 0 отключает сжатие
+
+	RetryDelays []time.Duration // задержки перед повторными попытками, пустой список отключает повторы
+}
+
+// outboundIP определяет адрес, с которого агент обращается к серверу.
+// UDP "соединение" не отправляет пакетов, а только выбирает маршрут
+func outboundIP(host string) (string, error) {
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return "", err
 	}
+	defer conn.Close()
 
-	return nil
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
 }
 
-func SendMetricsJSON(services *service.MetricService, host string, shakey string, logger *zap.SugaredLogger) error {
+func NewMetricsSender(ctx context.Context, services *service.MetricService, cfg SenderConfig, logger *zap.SugaredLogger) *MetricsSender {
+	scheme := "http"
+	client := &http.Client{}
+
+	if cfg.TLSConfig != nil {
+		scheme = "https"
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.TLSConfig
+		client.Transport = transport
+	}
+
+	realIP, err := outboundIP(cfg.Host)
+	if err != nil {
+		logger.Errorf("unable to detect outbound IP for %s: %v", cfg.Host, err)
+	}
 
-	url := fmt.Sprintf("http://%s/update/", host)
-	client := http.Client{}
+	retrier := xretry.NewRetrier(xretry.NewRetryPolicy(
+		xretry.WithDelays(cfg.RetryDelays...),
+		xretry.WithMaxDelay(maxRetryAfter),
+		xretry.WithRetryIf(IsRetryable),
+	))
+
+	return &MetricsSender{
+		ctx:       ctx,
+		services:  services,
+		host:      cfg.Host,
+		scheme:    scheme,
+		shaKey:    cfg.ShaKey,
+		token:     cfg.Token,
+		publicKey: cfg.PublicKey,
+		realIP:    realIP,
+		client:    client,
+		retrier:   retrier,
+		logger:    logger,
+
+		compressMinSize: cfg.CompressMinSize,
+		compressLevel:   cfg.CompressLevel,
+	}
+}
 
-	metricsPack, err := services.GetAllMetrics()
+// signRequest подписывает метод, URI и тело запроса вместе с текущим временем и случайным nonce,
+// чтобы сервер мог отклонить повторную отправку перехваченного запроса или её отправку на другой маршрут
+func signRequest(request *http.Request, shakey string, body []byte) error {
+	nonceBytes := make([]byte, 16)
+	_, err := rand.Read(nonceBytes)
 	if err != nil {
 		return err
 	}
 
-	logger.Infof("Sending metrics to %s", host)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(nonceBytes)
+	sign := utils.MakeHMACSign(shakey, utils.SignedPayload(request.Method, request.URL.RequestURI(), timestamp, nonce, body))
 
-	for _, el := range metricsPack {
+	request.Header.Set(middleware.TimestampHeader, timestamp)
+	request.Header.Set(middleware.NonceHeader, nonce)
+	request.Header.Set(middleware.HashSignHeader, hex.EncodeToString(sign))
+	return nil
+}
 
-		body, err := json.Marshal(el)
-		if err != nil {
-			logger.Error(err)
-			return err
-		}
+// compressBody сжимает тело запроса gzip с заданным уровнем
+func compressBody(body []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
 
-		bodySend := bytes.NewBuffer(body)
-		request, err := http.NewRequest(http.MethodPost, url, bodySend)
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = zw.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+newRequest собирает POST запрос: подпись считается по исходному телу, затем тело сжимается
+и шифруется. Сервер выполняет обратные шаги в порядке DecryptMiddleware, GzipMiddleware, HashSignMiddleware
+*/
+func (s *MetricsSender) newRequest(url string, body []byte, contentType string) (*http.Request, error) {
+	payload := body
+	var encryptedKey []byte
+
+	compressed := s.compressLevel != 0 && len(body) > 0 && len(body) >= s.compressMinSize
+	if compressed {
+		var err error
+		payload, err = compressBody(body, s.compressLevel)
 		if err != nil {
-			logger.Error(err)
-			return err
+			return nil, err
 		}
+	}
 
-		if shakey != "" {
-			sign := hex.EncodeToString(utils.MakeHMACSign(shakey, body))
-			request.Header.Set("HashSHA256", sign)
+	// пустое тело тоже шифруется: сервер с CRYPTO_KEY не принимает открытые запросы записи
+	if s.publicKey != nil {
+		var err error
+		encryptedKey, payload, err = utils.EncryptHybrid(s.publicKey, payload)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		request.Header.Set("Content-Type", "application/json")
-		request.Header.Set("Accept-Encoding", "gzip")
-
-		logger.Debugf("SEND %s %s", url, request.Body)
+	request, err := http.NewRequestWithContext(s.ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
 
-		response, err := client.Do(request)
+	if s.shaKey != "" {
+		err = signRequest(request, s.shaKey, body)
 		if err != nil {
-			logger.Error(err)
-			return nil
+			return nil, err
 		}
+	}
 
-		defer response.Body.Close()
-		logger.Debugf("RESP %s %s", url, response.Status)
+	if encryptedKey != nil {
+		request.Header.Set(middleware.EncryptedKeyHeader, base64.StdEncoding.EncodeToString(encryptedKey))
 	}
 
-	err = services.ResetCounterItem("PollCount")
-	if err != nil {
-		logger.Errorf("PollCount reset error")
+	if s.realIP != "" {
+		request.Header.Set(middleware.RealIPHeader, s.realIP)
 	}
 
-	return nil
+	if s.token != "" {
+		request.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	if compressed {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	return request, nil
 }
 
-func SendMetricsPackJSON(services *service.MetricService, host string, shakey string, logger *zap.SugaredLogger) error {
-	url := fmt.Sprintf("http://%s/updates/", host)
-	client := http.Client{}
+/*
+sendRequest отправляет POST запрос, ответ с кодом не из диапазона 2xx считается ошибкой.
+Временные ошибки (см. IsRetryable) повторяются с задержками из SenderConfig.RetryDelays,
+каждый повтор заново подписывается, чтобы не попасть под защиту от повторов на сервере
+*/
+func (s *MetricsSender) sendRequest(url string, body []byte, contentType string) error {
+	attempt := 0
+	return s.retrier.RetryContext(s.ctx, func() error {
+		attempt++
+		if attempt > 1 {
+			s.logger.Infof("retrying %s, attempt %d", url, attempt)
+		}
+
+		err := s.doRequest(url, body, contentType)
+		if err != nil && IsRetryable(err) {
+			s.logger.Debugf("temporary send error: %v", err)
+		}
+		return err
+	})
+}
 
-	metricsJSON, err := services.ExportToJSON()
+func (s *MetricsSender) doRequest(url string, body []byte, contentType string) error {
+	request, err := s.newRequest(url, body, contentType)
 	if err != nil {
-		logger.Error(err)
 		return err
 	}
-	bodySend := bytes.NewBuffer(metricsJSON)
 
-	request, err := http.NewRequest(http.MethodPost, url, bodySend)
+	response, err := s.client.Do(request)
 	if err != nil {
-		logger.Error(err)
 		return err
 	}
 
-	if shakey != "" {
-		sign := hex.EncodeToString(utils.MakeHMACSign(shakey, metricsJSON))
-		request.Header.Set("HashSHA256", sign)
+	defer response.Body.Close()
+	s.logger.Debugf("RESP %s %s", url, response.Status)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return &StatusError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			retryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Accept-Encoding", "gzip")
+	return nil
+}
 
-	logger.Debugf("SEND %s %s", url, request.Body)
+// SendMetricsPack отправляет подготовленную пачку метрик одним запросом на /updates/,
+// ответ сервера с кодом не из диапазона 2xx считается ошибкой
+func (s *MetricsSender) SendMetricsPack(metricsPack model.MetricsPack) error {
+	url := fmt.Sprintf("%s://%s/updates/", s.scheme, s.host)
 
-	response, err := client.Do(request)
+	metricsJSON, err := json.Marshal(metricsPack)
 	if err != nil {
-		logger.Error(err)
-		return nil
+		return err
 	}
 
-	defer response.Body.Close()
-	logger.Debugf("RESP %s %s", url, response.Status)
+	s.logger.Debugf("SEND %s %s", url, metricsJSON)
 
-	err = services.ResetCounterItem("PollCount")
+	return s.sendRequest(url, metricsJSON, "application/json")
+}
+
+// SendMetricsPackJSON отправляет снимок метрик, дельты счётчиков вычитаются только после ответа 2xx
+func (s *MetricsSender) SendMetricsPackJSON() error {
+	snapshot, err := s.services.SnapshotMetrics()
 	if err != nil {
-		logger.Errorf("PollCount reset error")
+		s.logger.Error(err)
+		return err
 	}
 
-	return nil
+	err = s.SendMetricsPack(snapshot.Metrics)
+	if err != nil {
+		s.logger.Error(err)
+		s.services.ReleaseSnapshot(snapshot)
+		return nil
+	}
+
+	return s.services.CommitSnapshot(snapshot)
 }
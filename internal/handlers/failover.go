@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"go.uber.org/zap"
+)
+
+var ErrorNoEndpoints = errors.New("no server endpoints configured")
+
+// pingTimeout ограничивает проверку доступности сервера через /ping
+const pingTimeout = 5 * time.Second
+
+// Host возвращает адрес сервера, на который отправляет метрики sender
+func (s *MetricsSender) Host() string {
+	return s.host
+}
+
+// Ping проверяет доступность сервера запросом GET /ping без повторов
+func (s *MetricsSender) Ping() error {
+	url := fmt.Sprintf("%s://%s/ping", s.scheme, s.host)
+
+	request, err := http.NewRequestWithContext(s.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := *s.client
+	client.Timeout = pingTimeout
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+	return nil
+}
+
+/*
+FailoverSender отправляет метрики на первый доступный сервер из списка в порядке приоритета
+и остаётся на нём, пока отправка успешна. Если активный сервер не отвечает, пачка
+отправляется следующему по списку. ProbeHigherPriority возвращает агента на более
+приоритетный сервер, как только тот снова отвечает на /ping.
+*/
+type FailoverSender struct {
+	mx      sync.Mutex
+	senders []*MetricsSender
+	active  int
+	logger  *zap.SugaredLogger
+}
+
+func NewFailoverSender(senders []*MetricsSender, logger *zap.SugaredLogger) *FailoverSender {
+	return &FailoverSender{senders: senders, logger: logger}
+}
+
+func (f *FailoverSender) activeIndex() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.active
+}
+
+func (f *FailoverSender) setActive(index int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.active != index {
+		f.logger.Warnf("switching active server from %s to %s", f.senders[f.active].Host(), f.senders[index].Host())
+		f.active = index
+	}
+}
+
+// SendMetricsPack отправляет пачку активному серверу, а при ошибке - остальным по кругу
+func (f *FailoverSender) SendMetricsPack(metricsPack model.MetricsPack) error {
+	if len(f.senders) == 0 {
+		return ErrorNoEndpoints
+	}
+
+	active := f.activeIndex()
+	var errs []error
+
+	for i := range f.senders {
+		index := (active + i) % len(f.senders)
+		sender := f.senders[index]
+
+		err := sender.SendMetricsPack(metricsPack)
+		if err == nil {
+			f.setActive(index)
+			return nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", sender.Host(), err))
+		if !IsRetryable(err) {
+			// сервер доступен, но отклонил пачку - другой сервер ответит так же
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ProbeHigherPriority проверяет /ping серверов с приоритетом выше активного и переключается на первый ответивший
+func (f *FailoverSender) ProbeHigherPriority() {
+	active := f.activeIndex()
+
+	for index := 0; index < active; index++ {
+		err := f.senders[index].Ping()
+		if err != nil {
+			f.logger.Debugf("server %s is still unavailable: %v", f.senders[index].Host(), err)
+			continue
+		}
+
+		f.setActive(index)
+		return
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/bbquite/mca-server/internal/ingest"
+	"github.com/bbquite/mca-server/internal/service"
+)
+
+// influxWrite принимает метрики в формате InfluxDB line protocol
+// POST /write?precision=ns|us|ms|s
+func (h *Handler) influxWrite(w http.ResponseWriter, r *http.Request) {
+	precision, err := ingest.InfluxPrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metricsPack, err := ingest.ParseInfluxLines(buf.String(), precision)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.logger.Debug(err)
+		return
+	}
+
+	err = h.services.AddMetricsPack(metricsPack)
+	if err != nil {
+		if errors.Is(err, service.ErrorInvalidMetric) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "", http.StatusInternalServerError)
+		h.logger.Error(err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
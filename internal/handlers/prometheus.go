@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// helpEscaper экранирует текст HELP-строки согласно формату экспозиции
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// SanitizePrometheusName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func SanitizePrometheusName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// prometheusCounterSuffix - суффикс имён counter-метрик по соглашению Prometheus
+const prometheusCounterSuffix = "_total"
+
+/*
+prometheusMetrics отдаёт все метрики в текстовом формате Prometheus.
+К именам counter-метрик добавляется суффикс _total, если его ещё нет
+*/
+func (h *Handler) prometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsPack, err := h.services.GetAllMetrics()
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		h.logger.Error(err)
+		return
+	}
+
+	sort.Slice(metricsPack, func(i, j int) bool {
+		return metricsPack[i].ID < metricsPack[j].ID
+	})
+
+	var buf bytes.Buffer
+	written := make(map[string]bool, len(metricsPack))
+
+	for _, el := range metricsPack {
+		name := SanitizePrometheusName(el.ID)
+
+		var value string
+		switch el.MType {
+		case "gauge":
+			value = strconv.FormatFloat(*el.Value, 'g', -1, 64)
+		case "counter":
+			value = strconv.FormatInt(*el.Delta, 10)
+			if !strings.HasSuffix(name, prometheusCounterSuffix) {
+				name += prometheusCounterSuffix
+			}
+		default:
+			continue
+		}
+
+		// после санитизации разные метрики могут получить одинаковое имя,
+		// повторное объявление сломает парсер на стороне Prometheus
+		if written[name] {
+			h.logger.Debugf("duplicate prometheus metric name %s (%s), skipped", name, el.ID)
+			continue
+		}
+		written[name] = true
+
+		fmt.Fprintf(&buf, "# HELP %s mca-server %s metric %s\n", name, el.MType, helpEscaper.Replace(el.ID))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, el.MType)
+		fmt.Fprintf(&buf, "%s %s\n", name, value)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
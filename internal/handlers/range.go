@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+)
+
+const (
+	defRangeDuration = time.Hour
+	defRangeStep     = time.Minute
+)
+
+// parseRangeTime принимает время в формате RFC3339 либо unix timestamp в секундах
+func parseRangeTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		sec, frac := int64(seconds), seconds-float64(int64(seconds))
+		return time.Unix(sec, int64(frac*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseRangeStep принимает длительность в формате time.ParseDuration ("30s", "5m") либо число секунд
+func parseRangeStep(value string) (time.Duration, error) {
+	if value == "" {
+		return defRangeStep, nil
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// rangeMetric отдаёт историю метрики за период, сгруппированную по интервалам step
+// GET /value/range?type=gauge&id=HeapAlloc&start=...&end=...&step=30s
+func (h *Handler) rangeMetric(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	mType := query.Get("type")
+	mName := query.Get("id")
+	if mName == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	end, err := parseRangeTime(query.Get("end"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start, err := parseRangeTime(query.Get("start"), end.Add(-defRangeDuration))
+	if err != nil {
+		http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	step, err := parseRangeStep(query.Get("step"))
+	if err != nil {
+		http.Error(w, "invalid step: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := h.services.GetMetricRange(mType, mName, start, end, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorGaugeNotFound), errors.Is(err, storage.ErrorCounterNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, service.ErrorInvalidRange),
+			errors.Is(err, service.ErrorInvalidStep),
+			errors.Is(err, service.ErrorTooManyPoints),
+			errors.Is(err, service.ErrorUnknownMetType):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "", http.StatusInternalServerError)
+			h.logger.Error(err)
+		}
+		return
+	}
+
+	if points == nil {
+		points = []model.RangePoint{}
+	}
+
+	resp, err := json.Marshal(model.RangeResult{
+		ID:     mName,
+		MType:  mType,
+		Start:  start,
+		End:    end,
+		Step:   step.String(),
+		Points: points,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Error(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/middleware"
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+var ErrorInvalidResponseSign = errors.New("invalid response signature")
+
+// maxScrapeBody ограничивает размер ответа агента
+const maxScrapeBody = 16 << 20
+
+var scrapeTargetSanitizer = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ScrapeTargetStatus - состояние опроса одного агента
+type ScrapeTargetStatus struct {
+	Target     string    `json:"target"`
+	Up         bool      `json:"up"`
+	LastScrape time.Time `json:"last_scrape,omitempty"`
+	Duration   float64   `json:"duration_seconds"`
+	Metrics    int       `json:"metrics"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+/*
+Scraper опрашивает агентов, к которым сервер может подключиться, но которые не могут
+отправлять метрики сами. Каждый агент опрашивается запросом GET /metrics со своим интервалом,
+полученные метрики сохраняются как при push. Состояние опроса сохраняется метриками
+ScrapeUp_<агент>, ScrapeDuration_<агент> (секунды) и ScrapeErrors_<агент> (счётчик).
+При заданном KEY запрос подписывается, а подпись ответа проверяется.
+
+Агент отдаёт накопленные значения counter-метрик и ничего не вычитает при опросе,
+дельты Scraper вычисляет сам относительно предыдущего успешного опроса агента.
+Первый опрос агента после запуска сервера только запоминает значения, уменьшение
+значения означает перезапуск агента, и дельтой считается новое значение целиком.
+*/
+type Scraper struct {
+	services *service.MetricService
+	targets  []string
+	interval time.Duration
+	shaKey   string
+	client   *http.Client
+	logger   *zap.SugaredLogger
+
+	mx       sync.RWMutex
+	status   map[string]*ScrapeTargetStatus
+	counters map[string]map[string]model.Counter // значения counter-метрик агента при последнем успешном опросе
+}
+
+// NewScraper создаёт опрос агентов, tlsConfig используется для адресов https:// (nil - системные настройки)
+func NewScraper(services *service.MetricService, targets []string, interval time.Duration, shaKey string, tlsConfig *tls.Config, logger *zap.SugaredLogger) *Scraper {
+	status := make(map[string]*ScrapeTargetStatus, len(targets))
+	for _, target := range targets {
+		status[target] = &ScrapeTargetStatus{Target: target}
+	}
+
+	client := &http.Client{Timeout: interval}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
+	return &Scraper{
+		services: services,
+		targets:  targets,
+		interval: interval,
+		shaKey:   shaKey,
+		client:   client,
+		logger:   logger,
+		status:   status,
+		counters: make(map[string]map[string]model.Counter, len(targets)),
+	}
+}
+
+// Run опрашивает всех агентов до отмены ctx
+func (s *Scraper) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, target := range s.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(s.interval)
+			defer ticker.Stop()
+
+			for {
+				s.ScrapeTarget(ctx, target)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Status возвращает состояние опроса агентов, отсортированное по адресу
+func (s *Scraper) Status() []ScrapeTargetStatus {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	result := make([]ScrapeTargetStatus, 0, len(s.status))
+	for _, status := range s.status {
+		result = append(result, *status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Target < result[j].Target })
+	return result
+}
+
+// targetURL добавляет к адресу агента схему http, если она не указана
+func targetURL(target string) string {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return strings.TrimSuffix(target, "/") + "/metrics"
+	}
+	return "http://" + target + "/metrics"
+}
+
+/*
+counterDeltas заменяет накопленные значения counter-метрик агента дельтами относительно
+предыдущего успешного опроса и возвращает новые значения для запоминания
+*/
+func (s *Scraper) counterDeltas(target string, metrics model.MetricsPack) (model.MetricsPack, map[string]model.Counter) {
+	s.mx.RLock()
+	previous, known := s.counters[target]
+	s.mx.RUnlock()
+
+	current := make(map[string]model.Counter)
+	result := make(model.MetricsPack, 0, len(metrics))
+
+	for _, metric := range metrics {
+		if metric.MType == "counter" && metric.Delta != nil {
+			total := model.Counter(*metric.Delta)
+			current[metric.ID] = total
+
+			delta := int64(0)
+			if known {
+				delta = int64(total - previous[metric.ID])
+				if total < previous[metric.ID] {
+					delta = int64(total)
+				}
+			}
+			metric.Delta = &delta
+		}
+		result = append(result, metric)
+	}
+
+	return result, current
+}
+
+// ScrapeTarget опрашивает агента target один раз и сохраняет полученные метрики и состояние опроса
+func (s *Scraper) ScrapeTarget(ctx context.Context, target string) {
+	start := time.Now()
+	metrics, err := s.fetch(ctx, target)
+	duration := time.Since(start)
+
+	var counters map[string]model.Counter
+	if err == nil {
+		metrics, counters = s.counterDeltas(target, metrics)
+		err = s.services.AddMetricsPack(metrics)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	s.mx.Lock()
+	status := s.status[target]
+	status.Up = err == nil
+	status.LastScrape = start
+	status.Duration = duration.Seconds()
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		// при ошибке сохранения запомненные значения не меняются, и дельты войдут в следующий опрос
+		status.Metrics = len(metrics)
+		s.counters[target] = counters
+	}
+	s.mx.Unlock()
+
+	name := scrapeTargetSanitizer.ReplaceAllString(strings.TrimPrefix(strings.TrimPrefix(target, "http://"), "https://"), "_")
+	up := 0.0
+	if err == nil {
+		up = 1
+	}
+
+	if _, saveErr := s.services.AddGaugeItem("ScrapeUp_"+name, model.Gauge(up)); saveErr != nil {
+		s.logger.Errorf("scrape status saving error: %v", saveErr)
+	}
+	if _, saveErr := s.services.AddGaugeItem("ScrapeDuration_"+name, model.Gauge(duration.Seconds())); saveErr != nil {
+		s.logger.Errorf("scrape status saving error: %v", saveErr)
+	}
+
+	if err != nil {
+		s.logger.Errorf("scrape %s error: %v", target, err)
+		if _, saveErr := s.services.AddCounterItem("ScrapeErrors_"+name, 1); saveErr != nil {
+			s.logger.Errorf("scrape status saving error: %v", saveErr)
+		}
+	}
+}
+
+func (s *Scraper) fetch(ctx context.Context, target string) (model.MetricsPack, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL(target), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.shaKey != "" {
+		err = signRequest(request, s.shaKey, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxScrapeBody))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	if s.shaKey != "" {
+		sign, err := hex.DecodeString(response.Header.Get(middleware.HashSignHeader))
+		if err != nil || !utils.CheckHMACEqual(s.shaKey, sign, body) {
+			return nil, ErrorInvalidResponseSign
+		}
+	}
+
+	var metrics model.MetricsPack
+	if err := json.Unmarshal(body, &metrics); err != nil {
+		return nil, fmt.Errorf("invalid metrics response: %w", err)
+	}
+	return metrics, nil
+}
+
+func (h *Handler) scrapeStatus(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(h.scraper.Status())
+	if err != nil {
+		h.logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+/*
+scrapeMetrics отдаёт серверу метрики агента с накопленными значениями счётчиков.
+Запрос ничего не изменяет, поэтому потерянный ответ или опрос несколькими серверами
+не приводит к потере дельт
+*/
+func (h *Handler) scrapeMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics, err := h.services.GetCumulativeMetrics()
+	if err != nil {
+		h.logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		h.logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// InitScrapeRoutes возвращает роутер агента для опроса сервером в режиме pull
+func (h *Handler) InitScrapeRoutes() *chi.Mux {
+	chiRouter := chi.NewRouter()
+
+	chiRouter.Use(middleware.RequestsLoggingMiddleware(h.logger))
+	chiRouter.Use(middleware.GzipMiddleware)
+	chiRouter.Use(middleware.HashSignMiddleware(h.shaKey, h.replayGuard, h.legacyBodySign, h.logger))
+
+	chiRouter.Get("/metrics", h.scrapeMetrics)
+
+	return chiRouter
+}
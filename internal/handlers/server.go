@@ -2,19 +2,21 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/rsa"
 	_ "embed"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"html/template"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/bbquite/mca-server/internal/auth"
 	"github.com/bbquite/mca-server/internal/middleware"
 	"github.com/bbquite/mca-server/internal/model"
 	"github.com/bbquite/mca-server/internal/service"
 	"github.com/bbquite/mca-server/internal/storage"
-	"github.com/bbquite/mca-server/internal/utils"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -22,24 +24,55 @@ import (
 //go:embed html/index.gohtml
 var htmlTemplateEmbed string
 
+// maxReplayNonces ограничивает количество запоминаемых nonce подписанных запросов
+const maxReplayNonces = 100000
+
 type Handler struct {
-	services      *service.MetricService
-	indexTemplate *template.Template
-	logger        *zap.SugaredLogger
-	shaKey        string
+	services       *service.MetricService
+	indexTemplate  *template.Template
+	logger         *zap.SugaredLogger
+	shaKey         string
+	replayGuard    *middleware.ReplayGuard
+	legacyBodySign bool
+	privateKey     *rsa.PrivateKey
+	trustedSubnet  *net.IPNet
+	tokens         *auth.TokenRegistry
+	scraper        *Scraper
+}
+
+// HandlerConfig - настройки защиты API, нулевое значение отключает все проверки
+type HandlerConfig struct {
+	ShaKey         string
+	ReplayWindow   time.Duration       // 0 отключает защиту подписанных запросов от повтора
+	LegacyBodySign bool                // принимать подпись только тела запроса, без метода, URI, времени и nonce
+	PrivateKey     *rsa.PrivateKey     // при заданном ключе запись метрик принимается только в зашифрованном виде
+	TrustedSubnet  *net.IPNet          // nil разрешает запись метрик с любого адреса
+	Tokens         *auth.TokenRegistry // nil отключает авторизацию по токенам
+	Scraper        *Scraper            // nil - сервер не опрашивает агентов, /scrape/targets не доступен
 }
 
-func NewHandler(services *service.MetricService, shaKey string, logger *zap.SugaredLogger) (*Handler, error) {
+func NewHandler(services *service.MetricService, cfg HandlerConfig, logger *zap.SugaredLogger) (*Handler, error) {
 	tml, err := template.New("indexTemplate").Parse(htmlTemplateEmbed)
 	if err != nil {
 		return &Handler{}, err
 	}
 
+	var replayGuard *middleware.ReplayGuard
+	if cfg.ShaKey != "" && cfg.ReplayWindow > 0 {
+		replayGuard = middleware.NewReplayGuard(cfg.ReplayWindow, maxReplayNonces)
+	}
+
 	return &Handler{
-		services:      services,
-		indexTemplate: tml,
-		logger:        logger,
-		shaKey:        shaKey,
+		services:       services,
+		indexTemplate:  tml,
+		logger:         logger,
+		shaKey:         cfg.ShaKey,
+		replayGuard:    replayGuard,
+		legacyBodySign: cfg.LegacyBodySign,
+		privateKey:     cfg.PrivateKey,
+		trustedSubnet:  cfg.TrustedSubnet,
+		tokens:         cfg.Tokens,
+		scraper:        cfg.Scraper,
 	}, nil
 }
 
@@ -48,25 +81,98 @@ func (h *Handler) InitChiRoutes() *chi.Mux {
 
 	chiRouter.Use(middleware.RequestsLoggingMiddleware(h.logger))
 	// chiRouter.Use(chiMiddleware.Logger)
+	chiRouter.Use(middleware.DecryptMiddleware(h.privateKey, h.logger))
 	chiRouter.Use(middleware.GzipMiddleware)
 
 	chiRouter.Route("/", func(r chi.Router) {
-		r.Get("/", h.renderMetricsPage)
 		r.Get("/ping", h.databasePing)
-		r.Route("/value/", func(r chi.Router) {
-			r.Post("/", h.valueMetricJSON)
-			r.Get("/{m_type}/{m_name}", h.valueMetricURI)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.TokenAuthMiddleware(h.tokens, auth.ScopeReadMetrics, h.logger))
+
+			r.Get("/", h.renderMetricsPage)
+			r.Get("/metrics", h.prometheusMetrics)
+
+			if h.scraper != nil {
+				r.Get("/scrape/targets", h.scrapeStatus)
+			}
+
+			// API метрик доступно только с подписью KEY, если он задан
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.HashSignMiddleware(h.shaKey, h.replayGuard, h.legacyBodySign, h.logger))
+
+				r.Route("/value/", func(r chi.Router) {
+					r.Post("/", h.valueMetricJSON)
+					r.Get("/range", h.rangeMetric)
+					r.Get("/{m_type}/{m_name}", h.valueMetricURI)
+				})
+			})
 		})
-		r.Route("/update/", func(r chi.Router) {
-			r.Post("/", h.updateMetricJSON)
-			r.Post("/{m_type}/{m_name}/{m_value}", h.updateMetricURI)
+
+		// запись метрик дополнительно ограничена доверенной подсетью
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.TrustedSubnetMiddleware(h.trustedSubnet, h.logger))
+			r.Use(middleware.TokenAuthMiddleware(h.tokens, auth.ScopeWriteMetrics, h.logger))
+			r.Use(middleware.RequireEncryptionMiddleware(h.privateKey, h.logger))
+			r.Use(middleware.HashSignMiddleware(h.shaKey, h.replayGuard, h.legacyBodySign, h.logger))
+
+			r.Route("/update/", func(r chi.Router) {
+				r.Post("/", h.updateMetricJSON)
+				r.Post("/{m_type}/{m_name}/{m_value}", h.updateMetricURI)
+			})
+			r.Post("/updates/", h.updatePackMetricsJSON)
 		})
-		r.Post("/updates/", h.updatePackMetricsJSON)
+
+		// Telegraf не умеет подписывать и шифровать запросы, поэтому /write защищён только токеном
+		// со scope write:metrics (http_headers = {"Authorization" = "Bearer <token>"}).
+		// Без TOKENS_FILE /write доступен, только если запись метрик не защищена KEY, CRYPTO_KEY и TRUSTED_SUBNET
+		if h.tokens != nil || (h.shaKey == "" && h.privateKey == nil && h.trustedSubnet == nil) {
+			r.With(middleware.TokenAuthMiddleware(h.tokens, auth.ScopeWriteMetrics, h.logger)).
+				Post("/write", h.influxWrite)
+		}
+
+		if h.tokens != nil {
+			r.With(middleware.TokenAuthMiddleware(h.tokens, auth.ScopeAdmin, h.logger)).
+				Post("/admin/tokens/reload", h.reloadTokens)
+		}
 	})
 
 	return chiRouter
 }
 
+/*
+InitSidecarRoutes возвращает роутер локального приёмника агента: тот же API записи метрик,
+что и у сервера, но без подписи, шифрования и авторизации. Приложения на хосте отправляют
+метрики агенту, а он пересылает их на сервер вместе со своими. Поэтому агент слушает
+только локальный адрес, если явно не задан SIDECAR_ALLOW_REMOTE
+*/
+func (h *Handler) InitSidecarRoutes() *chi.Mux {
+	chiRouter := chi.NewRouter()
+
+	chiRouter.Use(middleware.RequestsLoggingMiddleware(h.logger))
+	chiRouter.Use(middleware.GzipMiddleware)
+
+	chiRouter.Route("/update/", func(r chi.Router) {
+		r.Post("/", h.updateMetricJSON)
+		r.Post("/{m_type}/{m_name}/{m_value}", h.updateMetricURI)
+	})
+	chiRouter.Post("/updates/", h.updatePackMetricsJSON)
+
+	return chiRouter
+}
+
+func (h *Handler) reloadTokens(w http.ResponseWriter, r *http.Request) {
+	err := h.tokens.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Error(err)
+		return
+	}
+
+	h.logger.Infof("token registry reloaded: %d tokens", h.tokens.Len())
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) databasePing(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "text/plain")
 	err := h.services.PingDatabase()
@@ -87,25 +193,17 @@ func (h *Handler) updatePackMetricsJSON(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if h.shaKey != "" {
-		shaHeaderSign, err := hex.DecodeString(r.Header.Get("HashSHA256"))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			h.logger.Error(err)
-		}
-		if utils.CheckHMACEqual(h.shaKey, shaHeaderSign, buf.Bytes()) {
-			h.logger.Info("Норм подпись")
-		} else {
-			h.logger.Info("Подпись не оч")
-		}
-	}
-
 	h.logger.Debugf("| req %s", buf.Bytes())
 
 	err = h.services.ImportFromJSON(buf.Bytes())
 	if err != nil {
+		if errors.Is(err, service.ErrorInvalidMetric) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		h.logger.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -122,19 +220,6 @@ func (h *Handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.shaKey != "" {
-		shaHeaderSign, err := hex.DecodeString(r.Header.Get("HashSHA256"))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			h.logger.Error(err)
-		}
-		if utils.CheckHMACEqual(h.shaKey, shaHeaderSign, buf.Bytes()) {
-			h.logger.Info("Норм подпись")
-		} else {
-			h.logger.Info("Подпись не оч")
-		}
-	}
-
 	h.logger.Debugf("| req %s", buf.Bytes())
 
 	if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
@@ -191,6 +276,7 @@ func (h *Handler) updateMetricURI(w http.ResponseWriter, r *http.Request) {
 		metricValue, err := strconv.ParseFloat(mValue, 64)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
 
 		_, err = h.services.AddGaugeItem(mName, model.Gauge(metricValue))
@@ -207,6 +293,7 @@ func (h *Handler) updateMetricURI(w http.ResponseWriter, r *http.Request) {
 		metricValue, err := strconv.ParseInt(mValue, 10, 64)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
 
 		_, err = h.services.AddCounterItem(mName, model.Counter(metricValue))
@@ -257,19 +344,6 @@ func (h *Handler) valueMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.shaKey != "" {
-		shaHeaderSign, err := hex.DecodeString(r.Header.Get("HashSHA256"))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			h.logger.Error(err)
-		}
-		if utils.CheckHMACEqual(h.shaKey, shaHeaderSign, buf.Bytes()) {
-			h.logger.Info("Норм подпись")
-		} else {
-			h.logger.Info("Подпись не оч")
-		}
-	}
-
 	h.logger.Debugf("| req %s", buf.Bytes())
 
 	if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/model"
+	"go.uber.org/zap"
+)
+
+// backend - тестовый сервер, который отвечает на все запросы кодом status и считает принятые пачки
+type backend struct {
+	status  atomic.Int32
+	updates atomic.Int32
+	sender  *handlers.MetricsSender
+}
+
+func newBackend(t *testing.T, status int) *backend {
+	t.Helper()
+
+	b := &backend{}
+	b.status.Store(int32(status))
+	b.sender = newTestSender(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+		code := int(b.status.Load())
+		if r.URL.Path == "/updates/" && code == http.StatusOK {
+			b.updates.Add(1)
+		}
+		w.WriteHeader(code)
+	})
+	return b
+}
+
+func newFailover(backends ...*backend) *handlers.FailoverSender {
+	senders := make([]*handlers.MetricsSender, 0, len(backends))
+	for _, b := range backends {
+		senders = append(senders, b.sender)
+	}
+	return handlers.NewFailoverSender(senders, zap.NewNop().Sugar())
+}
+
+func Test_FailoverOrder(t *testing.T) {
+	value := 1.0
+	pack := model.MetricsPack{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	primary := newBackend(t, http.StatusServiceUnavailable)
+	secondary := newBackend(t, http.StatusServiceUnavailable)
+	reserve := newBackend(t, http.StatusOK)
+	failover := newFailover(primary, secondary, reserve)
+
+	if err := failover.SendMetricsPack(pack); err != nil {
+		t.Fatal(err)
+	}
+	if reserve.updates.Load() != 1 {
+		t.Fatalf("got %d packs on reserve, want 1", reserve.updates.Load())
+	}
+
+	// агент остаётся на рабочем сервере, даже если приоритетный снова доступен
+	secondary.status.Store(http.StatusOK)
+	if err := failover.SendMetricsPack(pack); err != nil {
+		t.Fatal(err)
+	}
+	if reserve.updates.Load() != 2 || secondary.updates.Load() != 0 {
+		t.Errorf("got reserve=%d secondary=%d, want 2 and 0", reserve.updates.Load(), secondary.updates.Load())
+	}
+}
+
+func Test_FailoverRejected(t *testing.T) {
+	value := 1.0
+	pack := model.MetricsPack{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	primary := newBackend(t, http.StatusBadRequest)
+	secondary := newBackend(t, http.StatusOK)
+	failover := newFailover(primary, secondary)
+
+	// окончательный отказ не передаётся следующему серверу
+	err := failover.SendMetricsPack(pack)
+	if err == nil || handlers.IsRetryable(err) {
+		t.Errorf("got %v, want non-retryable error", err)
+	}
+	if secondary.updates.Load() != 0 {
+		t.Errorf("got %d packs on secondary, want 0", secondary.updates.Load())
+	}
+}
+
+func Test_FailoverProbeHigherPriority(t *testing.T) {
+	value := 1.0
+	pack := model.MetricsPack{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	primary := newBackend(t, http.StatusServiceUnavailable)
+	secondary := newBackend(t, http.StatusOK)
+	failover := newFailover(primary, secondary)
+
+	if err := failover.SendMetricsPack(pack); err != nil {
+		t.Fatal(err)
+	}
+
+	// пока приоритетный сервер не отвечает на /ping, агент остаётся на резервном
+	failover.ProbeHigherPriority()
+	if err := failover.SendMetricsPack(pack); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.updates.Load() != 2 || primary.updates.Load() != 0 {
+		t.Fatalf("got primary=%d secondary=%d, want 0 and 2", primary.updates.Load(), secondary.updates.Load())
+	}
+
+	primary.status.Store(http.StatusOK)
+	failover.ProbeHigherPriority()
+	if err := failover.SendMetricsPack(pack); err != nil {
+		t.Fatal(err)
+	}
+	if primary.updates.Load() != 1 || secondary.updates.Load() != 2 {
+		t.Errorf("got primary=%d secondary=%d, want 1 and 2", primary.updates.Load(), secondary.updates.Load())
+	}
+}
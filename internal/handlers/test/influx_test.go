@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/auth"
+	"github.com/bbquite/mca-server/internal/handlers"
+	"go.uber.org/zap"
+)
+
+func Test_InfluxWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	err := os.WriteFile(path, []byte(`[
+		{"name": "telegraf", "token": "writer", "scopes": ["write:metrics"]},
+		{"name": "dashboard", "token": "reader", "scopes": ["read:metrics"]}
+	]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := auth.NewTokenRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const line = "net,interface=eth0 bytes_recv=42i"
+
+	tests := []struct {
+		name   string
+		cfg    handlers.HandlerConfig
+		url    string
+		body   string
+		token  string
+		status int
+	}{
+		{name: "no protection", url: "/write", body: line, status: http.StatusNoContent},
+		{name: "signed writes without tokens", cfg: handlers.HandlerConfig{ShaKey: "secret"}, url: "/write", body: line, status: http.StatusNotFound},
+		{name: "trusted subnet without tokens", cfg: handlers.HandlerConfig{TrustedSubnet: subnet}, url: "/write", body: line, status: http.StatusNotFound},
+		{name: "without token", cfg: handlers.HandlerConfig{ShaKey: "secret", Tokens: tokens}, url: "/write", body: line, status: http.StatusUnauthorized},
+		{name: "read token", cfg: handlers.HandlerConfig{ShaKey: "secret", Tokens: tokens}, url: "/write", body: line, token: "reader", status: http.StatusForbidden},
+		{name: "write token without signature and subnet", cfg: handlers.HandlerConfig{ShaKey: "secret", TrustedSubnet: subnet, Tokens: tokens}, url: "/write", body: line, token: "writer", status: http.StatusNoContent},
+		{name: "bad precision", cfg: handlers.HandlerConfig{Tokens: tokens}, url: "/write?precision=m", body: line, token: "writer", status: http.StatusBadRequest},
+		{name: "bad line", cfg: handlers.HandlerConfig{Tokens: tokens}, url: "/write", body: "net bytes_recv=4.2i", token: "writer", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			services := newServices(t)
+			handler, err := handlers.NewHandler(services, test.cfg, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := httptest.NewRequest(http.MethodPost, test.url, strings.NewReader(test.body))
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			w := httptest.NewRecorder()
+			handler.InitChiRoutes().ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), test.status)
+			}
+			if test.status != http.StatusNoContent {
+				return
+			}
+
+			// целые поля сохраняются как gauge без накопления
+			if value, err := services.GetGaugeItem("net.eth0.bytes_recv"); err != nil || value != 42 {
+				t.Errorf("got net.eth0.bytes_recv=%v (%v), want 42", value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"go.uber.org/zap"
+)
+
+func Test_SanitizePrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "HeapAlloc", want: "HeapAlloc"},
+		{name: "http:requests_in", want: "http:requests_in"},
+		{name: "9lives", want: "_9lives"},
+		{name: "gc2", want: "gc2"},
+		{name: "queue.size-max", want: "queue_size_max"},
+		{name: "cpu utilization", want: "cpu_utilization"},
+		{name: "память", want: "______"},
+		{name: "", want: "_"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := handlers.SanitizePrometheusName(test.name); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func Test_PrometheusMetrics(t *testing.T) {
+	services := newServices(t)
+	services.AddGaugeItem("Alloc", 1.5)
+	services.AddGaugeItem("queue.size", 3)
+	services.AddGaugeItem("queue-size", 4) // после санитизации совпадает с queue.size
+	services.AddGaugeItem("1min", 0.25)
+	services.AddGaugeItem("line\nbreak\\", 1)
+	services.AddCounterItem("PollCount", 7)
+	services.AddCounterItem("requests_total", 2)
+	services.AddGaugeItem("PollCount_total", 9) // совпадает с именем counter-метрики PollCount
+
+	handler, err := handlers.NewHandler(services, handlers.HandlerConfig{}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.InitChiRoutes().ServeHTTP(w, request)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q", contentType)
+	}
+	body := w.Body.String()
+
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{
+			name: "gauge",
+			lines: []string{
+				"# HELP Alloc mca-server gauge metric Alloc",
+				"# TYPE Alloc gauge",
+				"Alloc 1.5",
+			},
+		},
+		{
+			name: "counter with suffix",
+			lines: []string{
+				"# HELP PollCount_total mca-server counter metric PollCount",
+				"# TYPE PollCount_total counter",
+				"PollCount_total 7",
+			},
+		},
+		{
+			name: "counter already with suffix",
+			lines: []string{
+				"# TYPE requests_total counter",
+				"requests_total 2",
+			},
+		},
+		{
+			name:  "leading digit",
+			lines: []string{"# TYPE _1min gauge", "_1min 0.25"},
+		},
+		{
+			name:  "escaped help",
+			lines: []string{`# HELP line_break_ mca-server gauge metric line\nbreak\\`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, line := range test.lines {
+				if !strings.Contains(body, line+"\n") {
+					t.Errorf("line %q not found in:\n%s", line, body)
+				}
+			}
+		})
+	}
+
+	// из метрик с одинаковым именем после санитизации отдаётся первая по исходному имени
+	t.Run("collisions are skipped", func(t *testing.T) {
+		for name, want := range map[string]string{"queue_size": "queue_size 4", "PollCount_total": "PollCount_total 7"} {
+			if count := strings.Count(body, "# TYPE "+name+" "); count != 1 {
+				t.Errorf("got %d TYPE lines for %s, want 1", count, name)
+			}
+			if !strings.Contains(body, want+"\n") {
+				t.Errorf("line %q not found in:\n%s", want, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+	"go.uber.org/zap"
+)
+
+func Test_RangeMetric(t *testing.T) {
+	services, err := service.NewMetricService(storage.NewMemStorage(storage.DefaultHistoryLimit), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	at := func(offset time.Duration) *int64 {
+		ts := base.Add(offset).UnixMilli()
+		return &ts
+	}
+	value := func(v float64) *float64 { return &v }
+	delta := func(v int64) *int64 { return &v }
+
+	err = services.AddMetricsPack(model.MetricsPack{
+		{ID: "Alloc", MType: "gauge", Value: value(1), TS: at(10 * time.Second)},
+		{ID: "Alloc", MType: "gauge", Value: value(3), TS: at(70 * time.Second)},
+		{ID: "hits", MType: "counter", Delta: delta(2), TS: at(-30 * time.Second)},
+		{ID: "hits", MType: "counter", Delta: delta(3), TS: at(10 * time.Second)},
+		{ID: "hits", MType: "counter", Delta: delta(5), TS: at(70 * time.Second)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := handlers.NewHandler(services, handlers.HandlerConfig{}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := handler.InitChiRoutes()
+
+	start := strconv.FormatInt(base.Unix(), 10)
+	end := strconv.FormatInt(base.Add(2*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		query     string
+		status    int
+		points    int
+		increases []float64
+	}{
+		{name: "gauge with unix timestamps", query: "type=gauge&id=Alloc&start=" + start + "&end=" + end + "&step=60", status: http.StatusOK, points: 2},
+		{name: "gauge with RFC3339 and duration step", query: "type=gauge&id=Alloc&start=" + base.Format(time.RFC3339) + "&end=" + base.Add(2*time.Minute).Format(time.RFC3339) + "&step=2m", status: http.StatusOK, points: 1},
+		{name: "counter seeded before start", query: "type=counter&id=hits&start=" + start + "&end=" + end + "&step=1m", status: http.StatusOK, points: 2, increases: []float64{3, 5}},
+		{name: "default range is empty", query: "type=gauge&id=Alloc&end=" + start, status: http.StatusOK, points: 0},
+		{name: "missing id", query: "type=gauge", status: http.StatusBadRequest},
+		{name: "invalid start", query: "type=gauge&id=Alloc&start=yesterday", status: http.StatusBadRequest},
+		{name: "invalid end", query: "type=gauge&id=Alloc&end=2024-13-01", status: http.StatusBadRequest},
+		{name: "invalid step", query: "type=gauge&id=Alloc&step=often", status: http.StatusBadRequest},
+		{name: "zero step", query: "type=gauge&id=Alloc&step=0", status: http.StatusBadRequest},
+		{name: "start after end", query: "type=gauge&id=Alloc&start=" + end + "&end=" + start, status: http.StatusBadRequest},
+		{name: "too many points", query: "type=gauge&id=Alloc&start=" + start + "&end=" + strconv.FormatInt(base.Add(24*time.Hour).Unix(), 10) + "&step=1s", status: http.StatusBadRequest},
+		{name: "unknown type", query: "type=histogram&id=Alloc", status: http.StatusBadRequest},
+		{name: "unknown gauge", query: "type=gauge&id=Missing", status: http.StatusNotFound},
+		{name: "unknown counter", query: "type=counter&id=Missing", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/value/range?"+test.query, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var result model.RangeResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Points) != test.points {
+				t.Fatalf("got %d points, want %d", len(result.Points), test.points)
+			}
+			for i, increase := range test.increases {
+				if p := result.Points[i]; p.Increase == nil || *p.Increase != increase {
+					t.Errorf("point %d increase = %v, want %v", i, p.Increase, increase)
+				}
+			}
+		})
+	}
+}
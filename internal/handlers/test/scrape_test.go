@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/middleware"
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+	"github.com/bbquite/mca-server/internal/utils"
+	"go.uber.org/zap"
+)
+
+const scrapeKey = "scrape-secret"
+
+func newServices(t *testing.T) *service.MetricService {
+	t.Helper()
+
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return services
+}
+
+func scrapeRoutes(t *testing.T, services *service.MetricService) http.Handler {
+	t.Helper()
+
+	handler, err := handlers.NewHandler(services, handlers.HandlerConfig{
+		ShaKey:       scrapeKey,
+		ReplayWindow: time.Minute,
+	}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler.InitScrapeRoutes()
+}
+
+// newScrapeAgent запускает маршруты агента для опроса в режиме pull
+func newScrapeAgent(t *testing.T, services *service.MetricService) *httptest.Server {
+	t.Helper()
+
+	agent := httptest.NewServer(scrapeRoutes(t, services))
+	t.Cleanup(agent.Close)
+	return agent
+}
+
+func scrapeOnce(t *testing.T, services *service.MetricService, target string, key string) handlers.ScrapeTargetStatus {
+	t.Helper()
+
+	scraper := handlers.NewScraper(services, []string{target}, time.Second, key, nil, zap.NewNop().Sugar())
+	scraper.ScrapeTarget(context.Background(), target)
+	return scraper.Status()[0]
+}
+
+func counterValue(t *testing.T, services *service.MetricService, key string) model.Counter {
+	t.Helper()
+
+	value, err := services.GetCounterItem(key)
+	if err != nil {
+		t.Fatalf("counter %s: %v", key, err)
+	}
+	return value
+}
+
+func Test_ScrapeUp(t *testing.T) {
+	agentServices := newServices(t)
+	agentServices.AddGaugeItem("Alloc", 42)
+	agent := newScrapeAgent(t, agentServices)
+
+	serverServices := newServices(t)
+	status := scrapeOnce(t, serverServices, agent.URL, scrapeKey)
+
+	if !status.Up || status.LastError != "" || status.Metrics != 1 {
+		t.Errorf("got %+v, want up with 1 metric", status)
+	}
+	if value, err := serverServices.GetGaugeItem("Alloc"); err != nil || value != 42 {
+		t.Errorf("got Alloc=%v (%v), want 42", value, err)
+	}
+
+	name := "ScrapeUp_" + strings.NewReplacer(".", "_", ":", "_").Replace(strings.TrimPrefix(agent.URL, "http://"))
+	if up, err := serverServices.GetGaugeItem(name); err != nil || up != 1 {
+		t.Errorf("got %s=%v (%v), want 1", name, up, err)
+	}
+}
+
+func Test_ScrapeDown(t *testing.T) {
+	agent := newScrapeAgent(t, newServices(t))
+	agent.Close()
+
+	serverServices := newServices(t)
+	status := scrapeOnce(t, serverServices, agent.URL, scrapeKey)
+
+	if status.Up || status.LastError == "" || status.Metrics != 0 {
+		t.Errorf("got %+v, want down with error", status)
+	}
+
+	name := "ScrapeErrors_" + strings.NewReplacer(".", "_", ":", "_").Replace(strings.TrimPrefix(agent.URL, "http://"))
+	if errorsCount := counterValue(t, serverServices, name); errorsCount != 1 {
+		t.Errorf("got %s=%d, want 1", name, errorsCount)
+	}
+}
+
+func Test_ScrapeSignatureMismatch(t *testing.T) {
+	agentServices := newServices(t)
+	agentServices.AddGaugeItem("Alloc", 42)
+	agent := newScrapeAgent(t, agentServices)
+
+	t.Run("request signed with another key", func(t *testing.T) {
+		status := scrapeOnce(t, newServices(t), agent.URL, "wrong-key")
+		if status.Up || !strings.Contains(status.LastError, "401") {
+			t.Errorf("got %+v, want rejected request", status)
+		}
+	})
+
+	t.Run("response signed with another key", func(t *testing.T) {
+		fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+			w.Header().Set(middleware.HashSignHeader, hex.EncodeToString(utils.MakeHMACSign("wrong-key", body)))
+			w.Write(body)
+		}))
+		defer fake.Close()
+
+		serverServices := newServices(t)
+		status := scrapeOnce(t, serverServices, fake.URL, scrapeKey)
+		if status.Up || status.LastError != handlers.ErrorInvalidResponseSign.Error() {
+			t.Errorf("got %+v, want %v", status, handlers.ErrorInvalidResponseSign)
+		}
+		if _, err := serverServices.GetGaugeItem("Alloc"); err == nil {
+			t.Error("metrics from response with invalid signature are saved")
+		}
+	})
+}
+
+func Test_ScrapeCounters(t *testing.T) {
+	agentServices := newServices(t)
+	agentServices.AddCounterItem("PollCount", 5)
+
+	// перезапуск агента имитируется заменой обработчика на том же адресе
+	var routes atomic.Value
+	routes.Store(scrapeRoutes(t, agentServices))
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes.Load().(http.Handler).ServeHTTP(w, r)
+	}))
+	defer agent.Close()
+
+	serverServices := newServices(t)
+	scraper := handlers.NewScraper(serverServices, []string{agent.URL}, time.Second, scrapeKey, nil, zap.NewNop().Sugar())
+
+	// первый опрос только запоминает накопленное значение
+	scraper.ScrapeTarget(context.Background(), agent.URL)
+	if got := counterValue(t, serverServices, "PollCount"); got != 0 {
+		t.Errorf("after first scrape got PollCount=%d, want 0", got)
+	}
+
+	// опрос другим сервером не забирает дельты у первого
+	agentServices.AddCounterItem("PollCount", 3)
+	if status := scrapeOnce(t, newServices(t), agent.URL, scrapeKey); !status.Up {
+		t.Fatalf("second server scrape failed: %s", status.LastError)
+	}
+
+	scraper.ScrapeTarget(context.Background(), agent.URL)
+	if got := counterValue(t, serverServices, "PollCount"); got != 3 {
+		t.Errorf("after second scrape got PollCount=%d, want 3", got)
+	}
+	if got := counterValue(t, agentServices, "PollCount"); got != 8 {
+		t.Errorf("agent PollCount=%d after scrapes, want 8", got)
+	}
+
+	// подтверждённая push-отправка не уменьшает значение, которое видит сервер
+	snapshot, err := agentServices.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agentServices.CommitSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	agentServices.AddCounterItem("PollCount", 1)
+
+	scraper.ScrapeTarget(context.Background(), agent.URL)
+	if got := counterValue(t, serverServices, "PollCount"); got != 4 {
+		t.Errorf("after push commit got PollCount=%d, want 4", got)
+	}
+
+	// после перезапуска агента счёт начинается заново
+	restarted := newServices(t)
+	restarted.AddCounterItem("PollCount", 2)
+	routes.Store(scrapeRoutes(t, restarted))
+
+	scraper.ScrapeTarget(context.Background(), agent.URL)
+	if got := counterValue(t, serverServices, "PollCount"); got != 6 {
+		t.Errorf("after agent restart got PollCount=%d, want 6", got)
+	}
+}
+
+func Test_ScrapeSaveError(t *testing.T) {
+	var response atomic.Value
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := response.Load().([]byte)
+		w.Header().Set(middleware.HashSignHeader, hex.EncodeToString(utils.MakeHMACSign(scrapeKey, body)))
+		w.Write(body)
+	}))
+	defer fake.Close()
+
+	serverServices := newServices(t)
+	scraper := handlers.NewScraper(serverServices, []string{fake.URL}, time.Second, scrapeKey, nil, zap.NewNop().Sugar())
+
+	response.Store([]byte(`[{"id":"PollCount","type":"counter","delta":5}]`))
+	scraper.ScrapeTarget(context.Background(), fake.URL)
+
+	// gauge без значения не сохраняется, и вся пачка отклоняется
+	response.Store([]byte(`[{"id":"PollCount","type":"counter","delta":7},{"id":"Alloc","type":"gauge"}]`))
+	scraper.ScrapeTarget(context.Background(), fake.URL)
+
+	status := scraper.Status()[0]
+	if status.Up || status.Metrics != 1 {
+		t.Errorf("got %+v, want down with metrics count of the last saved scrape", status)
+	}
+
+	// непринятая дельта входит в следующий успешный опрос
+	response.Store([]byte(`[{"id":"PollCount","type":"counter","delta":9}]`))
+	scraper.ScrapeTarget(context.Background(), fake.URL)
+	if got := counterValue(t, serverServices, "PollCount"); got != 4 {
+		t.Errorf("got PollCount=%d, want 4", got)
+	}
+}
+
+func Test_ScrapeTLS(t *testing.T) {
+	agentServices := newServices(t)
+	agentServices.AddGaugeItem("Alloc", 42)
+	agent := httptest.NewTLSServer(scrapeRoutes(t, agentServices))
+	defer agent.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: agent.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := utils.NewClientTLSConfig(caPath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("without CA", func(t *testing.T) {
+		status := scrapeOnce(t, newServices(t), agent.URL, scrapeKey)
+		if status.Up || !strings.Contains(status.LastError, "certificate") {
+			t.Errorf("got %+v, want certificate error", status)
+		}
+	})
+
+	t.Run("with CA", func(t *testing.T) {
+		serverServices := newServices(t)
+		scraper := handlers.NewScraper(serverServices, []string{agent.URL}, time.Second, scrapeKey, tlsConfig, zap.NewNop().Sugar())
+		scraper.ScrapeTarget(context.Background(), agent.URL)
+
+		if status := scraper.Status()[0]; !status.Up {
+			t.Fatalf("got %+v, want up", status)
+		}
+		if value, err := serverServices.GetGaugeItem("Alloc"); err != nil || value != 42 {
+			t.Errorf("got Alloc=%v (%v), want 42", value, err)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/model"
+	"go.uber.org/zap"
+)
+
+func newTestSender(t *testing.T, ctx context.Context, handler http.HandlerFunc, delays ...time.Duration) *handlers.MetricsSender {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	if len(delays) == 0 {
+		delays = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond}
+	}
+
+	return handlers.NewMetricsSender(ctx, nil, handlers.SenderConfig{
+		Host:        strings.TrimPrefix(server.URL, "http://"),
+		RetryDelays: delays,
+	}, zap.NewNop().Sugar())
+}
+
+func Test_SendMetricsPackRetry(t *testing.T) {
+	value := 1.0
+	pack := model.MetricsPack{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	t.Run("retry on 503", func(t *testing.T) {
+		var calls atomic.Int32
+		sender := newTestSender(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
+		if err := sender.SendMetricsPack(pack); err != nil {
+			t.Fatal(err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("got %d calls, want 3", calls.Load())
+		}
+	})
+
+	t.Run("no retry on 400", func(t *testing.T) {
+		var calls atomic.Int32
+		sender := newTestSender(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		})
+
+		err := sender.SendMetricsPack(pack)
+		if !errors.Is(err, handlers.ErrorUnexpectedStatus) {
+			t.Errorf("got %v, want %v", err, handlers.ErrorUnexpectedStatus)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("got %d calls, want 1", calls.Load())
+		}
+	})
+}
+
+func Test_IsRetryableJoined(t *testing.T) {
+	unavailable := &handlers.StatusError{StatusCode: http.StatusServiceUnavailable}
+	badRequest := &handlers.StatusError{StatusCode: http.StatusBadRequest}
+
+	// failover: первый сервер недоступен, второй отклонил пачку - повтор бесполезен
+	if handlers.IsRetryable(errors.Join(unavailable, badRequest)) {
+		t.Error("joined 503 and 400 must not be retryable")
+	}
+	if !handlers.IsRetryable(errors.Join(unavailable, unavailable)) {
+		t.Error("joined 503 errors must be retryable")
+	}
+}
+
+func Test_SendMetricsPackCancel(t *testing.T) {
+	value := 1.0
+	pack := model.MetricsPack{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := newTestSender(t, ctx, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, 10*time.Second, 10*time.Second)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := sender.SendMetricsPack(pack)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("send took %v after cancel, want prompt return", elapsed)
+	}
+}
+
+func Test_SendMetricsPackTLSError(t *testing.T) {
+	value := 1.0
+	pack := model.MetricsPack{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	// сертификат тестового сервера не подписан доверенным CA
+	sender := handlers.NewMetricsSender(context.Background(), nil, handlers.SenderConfig{
+		Host:        strings.TrimPrefix(server.URL, "https://"),
+		TLSConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+		RetryDelays: []time.Duration{10 * time.Second},
+	}, zap.NewNop().Sugar())
+
+	start := time.Now()
+	err := sender.SendMetricsPack(pack)
+	if err == nil {
+		t.Fatal("expected certificate verification error")
+	}
+	if handlers.IsRetryable(err) {
+		t.Errorf("certificate error must not be retryable: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("send took %v, certificate error was retried", elapsed)
+	}
+}
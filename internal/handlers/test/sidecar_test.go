@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+	"go.uber.org/zap"
+)
+
+func Test_SidecarPushInSnapshot(t *testing.T) {
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := handlers.NewHandler(services, handlers.HandlerConfig{}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler.InitSidecarRoutes())
+	defer server.Close()
+
+	body := `[{"id":"jobs_done","type":"counter","delta":3},{"id":"queue_len","type":"gauge","value":7.5}]`
+	response, err := http.Post(server.URL+"/updates/", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", response.StatusCode)
+	}
+
+	response, err = http.Post(server.URL+"/update/counter/jobs_done/2", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	snapshot, err := services.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer services.ReleaseSnapshot(snapshot)
+
+	var delta int64
+	var value float64
+	for _, metric := range snapshot.Metrics {
+		switch {
+		case metric.ID == "jobs_done" && metric.Delta != nil:
+			delta = *metric.Delta
+		case metric.ID == "queue_len" && metric.Value != nil:
+			value = *metric.Value
+		}
+	}
+	if delta != 5 || value != 7.5 {
+		t.Errorf("got jobs_done=%d queue_len=%v in snapshot, want 5 and 7.5", delta, value)
+	}
+}
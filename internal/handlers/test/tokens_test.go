@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/auth"
+	"github.com/bbquite/mca-server/internal/handlers"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+	"go.uber.org/zap"
+)
+
+func Test_TokenScopes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	err := os.WriteFile(path, []byte(`[
+		{"name": "agent", "token": "writer", "scopes": ["write:metrics"]},
+		{"name": "dashboard", "token": "reader", "scopes": ["read:metrics"]},
+		{"name": "ops", "token": "root", "scopes": ["admin"]}
+	]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := auth.NewTokenRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.AddGaugeItem("Alloc", 1)
+
+	handler, err := handlers.NewHandler(services, handlers.HandlerConfig{Tokens: tokens}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := handler.InitChiRoutes()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		want   int
+	}{
+		{name: "write without token", method: http.MethodPost, url: "/update/gauge/Alloc/2", want: http.StatusUnauthorized},
+		{name: "write with unknown token", method: http.MethodPost, url: "/update/gauge/Alloc/2", token: "nobody", want: http.StatusUnauthorized},
+		{name: "write with read token", method: http.MethodPost, url: "/update/gauge/Alloc/2", token: "reader", want: http.StatusForbidden},
+		{name: "write with write token", method: http.MethodPost, url: "/update/gauge/Alloc/2", token: "writer", want: http.StatusOK},
+		{name: "read with write token", method: http.MethodGet, url: "/value/gauge/Alloc", token: "writer", want: http.StatusForbidden},
+		{name: "read with read token", method: http.MethodGet, url: "/value/gauge/Alloc", token: "reader", want: http.StatusOK},
+		{name: "read with admin token", method: http.MethodGet, url: "/value/gauge/Alloc", token: "root", want: http.StatusOK},
+		{name: "reload with write token", method: http.MethodPost, url: "/admin/tokens/reload", token: "writer", want: http.StatusForbidden},
+		{name: "reload with admin token", method: http.MethodPost, url: "/admin/tokens/reload", token: "root", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.url, nil)
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, request)
+			if w.Code != test.want {
+				t.Errorf("got %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+
+	t.Run("reload with malformed registry", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte(`[{"name": "ops"`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		request := httptest.NewRequest(http.MethodPost, "/admin/tokens/reload", nil)
+		request.Header.Set("Authorization", "Bearer root")
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("got %d, want %d", w.Code, http.StatusInternalServerError)
+		}
+		if _, ok := tokens.Lookup("root"); !ok {
+			t.Error("admin token lost after failed reload")
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/handlers"
+	"go.uber.org/zap"
+)
+
+func Test_UpdatePackMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		stored bool
+	}{
+		{
+			name:   "valid pack",
+			body:   `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2}]`,
+			status: http.StatusOK,
+			stored: true,
+		},
+		{
+			name:   "empty pack",
+			body:   `[]`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "gauge without value",
+			body:   `[{"id":"PollCount","type":"counter","delta":2},{"id":"Alloc","type":"gauge"}]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "counter without delta",
+			body:   `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter"}]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown type",
+			body:   `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"latency","type":"histogram","value":1}]`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			services := newServices(t)
+			handler, err := handlers.NewHandler(services, handlers.HandlerConfig{}, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			handler.InitChiRoutes().ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Fatalf("got status %d (%s), want %d", w.Code, w.Body.String(), test.status)
+			}
+
+			// пачка с ошибкой отклоняется целиком
+			_, gaugeErr := services.GetGaugeItem("Alloc")
+			_, counterErr := services.GetCounterItem("PollCount")
+			if stored := gaugeErr == nil || counterErr == nil; stored != test.stored {
+				t.Errorf("got stored=%v, want %v", stored, test.stored)
+			}
+		})
+	}
+}
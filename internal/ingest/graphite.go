@@ -0,0 +1,234 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"go.uber.org/zap"
+)
+
+const (
+	graphiteFlushInterval = time.Second
+	graphiteMaxBatch      = 1000
+	graphiteIdleTimeout   = 5 * time.Minute // подключение без данных дольше этого времени закрывается
+)
+
+var ErrorGraphiteFormat = errors.New("invalid graphite line")
+
+// MatchGraphitePattern сравнивает путь метрики с шаблоном посегментно:
+// "servers.*.cpu.total" подходит для "servers.web1.cpu.total", но не для "servers.web1.cpu"
+func MatchGraphitePattern(pattern string, metricPath string) bool {
+	patternParts := strings.Split(pattern, ".")
+	pathParts := strings.Split(metricPath, ".")
+	if len(patternParts) != len(pathParts) {
+		return false
+	}
+
+	for i := range patternParts {
+		ok, err := path.Match(patternParts[i], pathParts[i])
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseGraphiteLine разбирает строку "path.to.metric value [timestamp]",
+// метрики, подходящие под один из counterPatterns, сохраняются как counter
+func ParseGraphiteLine(line string, counterPatterns []string) (model.Metric, error) {
+	var metric model.Metric
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return metric, fmt.Errorf("%w: %q", ErrorGraphiteFormat, line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return metric, fmt.Errorf("%w: bad value in %q", ErrorGraphiteFormat, line)
+	}
+
+	metric.ID = fields[0]
+
+	// timestamp -1 или N по соглашению carbon означает "сейчас"
+	if len(fields) == 3 && fields[2] != "-1" && fields[2] != "N" {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return metric, fmt.Errorf("%w: bad timestamp in %q", ErrorGraphiteFormat, line)
+		}
+		ts := int64(seconds * 1000)
+		metric.TS = &ts
+	}
+
+	for _, pattern := range counterPatterns {
+		if MatchGraphitePattern(pattern, metric.ID) {
+			delta := int64(math.Round(value))
+			metric.MType = "counter"
+			metric.Delta = &delta
+			return metric, nil
+		}
+	}
+
+	metric.MType = "gauge"
+	metric.Value = &value
+	return metric, nil
+}
+
+// GraphiteServer принимает метрики в формате Graphite plaintext по TCP
+// и сохраняет их пачками через MetricService.AddMetricsPack
+type GraphiteServer struct {
+	addr            string
+	counterPatterns []string
+	services        *service.MetricService
+	logger          *zap.SugaredLogger
+
+	mx   sync.Mutex
+	pack model.MetricsPack
+}
+
+func NewGraphiteServer(addr string, counterPatterns []string, services *service.MetricService, logger *zap.SugaredLogger) *GraphiteServer {
+	return &GraphiteServer{
+		addr:            addr,
+		counterPatterns: counterPatterns,
+		services:        services,
+		logger:          logger,
+	}
+}
+
+// Run принимает подключения до отмены контекста, после чего закрывает их и сбрасывает накопленные метрики
+func (s *GraphiteServer) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	var connWG sync.WaitGroup
+	var connMx sync.Mutex
+	conns := make(map[net.Conn]struct{})
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+
+		connMx.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		connMx.Unlock()
+	}()
+
+	flushTicker := time.NewTicker(graphiteFlushInterval)
+	defer flushTicker.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-flushTicker.C:
+				s.Flush()
+			}
+		}
+	}()
+
+	s.logger.Infof("Graphite listener started on %s", s.addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			s.logger.Errorf("graphite accept error: %v", err)
+			continue
+		}
+
+		connMx.Lock()
+		conns[conn] = struct{}{}
+		if ctx.Err() != nil {
+			conn.Close()
+		}
+		connMx.Unlock()
+
+		connWG.Add(1)
+		go func() {
+			defer connWG.Done()
+			s.handleConn(conn)
+
+			connMx.Lock()
+			delete(conns, conn)
+			connMx.Unlock()
+		}()
+	}
+
+	connWG.Wait()
+	s.Flush()
+	return nil
+}
+
+func (s *GraphiteServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(graphiteIdleTimeout)); err != nil {
+			s.logger.Debugf("graphite connection %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		metric, err := ParseGraphiteLine(line, s.counterPatterns)
+		if err != nil {
+			s.logger.Debug(err)
+			continue
+		}
+
+		s.mx.Lock()
+		s.pack = append(s.pack, metric)
+		full := len(s.pack) >= graphiteMaxBatch
+		s.mx.Unlock()
+
+		if full {
+			s.Flush()
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logger.Debugf("graphite connection %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// Flush сохраняет накопленные метрики одной пачкой
+func (s *GraphiteServer) Flush() {
+	s.mx.Lock()
+	pack := s.pack
+	s.pack = nil
+	s.mx.Unlock()
+
+	if len(pack) == 0 {
+		return
+	}
+
+	s.logger.Debugf("graphite flush: %d metrics", len(pack))
+	if err := s.services.AddMetricsPack(pack); err != nil {
+		s.logger.Errorf("graphite flush error: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+var (
+	ErrorInfluxFormat    = errors.New("invalid line protocol")
+	ErrorInfluxPrecision = errors.New("unsupported precision")
+)
+
+// InfluxPrecision возвращает единицу измерения timestamp по значению параметра precision
+func InfluxPrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrorInfluxPrecision, precision)
+	}
+}
+
+// splitUnescaped делит строку по sep, пропуская экранированные символы и, если нужно, содержимое кавычек
+func splitUnescaped(s string, sep byte, quoted bool, limit int) []string {
+	var parts []string
+	var inQuotes bool
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			if limit > 0 && len(parts) == limit-1 {
+				continue
+			}
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`)
+
+/*
+ParseInfluxLine разбирает строку формата
+measurement[,tag=value...] field=value[,field=value...] [timestamp]
+
+Имя метрики строится как measurement, значения тегов (по алфавиту ключей) и имя поля через точку:
+"cpu,cpu=cpu0,host=web1 usage_idle=98.5" -> "cpu.cpu0.web1.usage_idle".
+Все числовые поля, в том числе целые с суффиксом i (u), сохраняются как gauge:
+Telegraf передаёт в них текущие или уже накопленные значения, а не приращения.
+Строковые и логические поля пропускаются.
+*/
+func ParseInfluxLine(line string, precision time.Duration) (model.MetricsPack, error) {
+	sections := splitUnescaped(line, ' ', true, 0)
+
+	var nonEmpty []string
+	for _, section := range sections {
+		if section != "" {
+			nonEmpty = append(nonEmpty, section)
+		}
+	}
+	if len(nonEmpty) < 2 || len(nonEmpty) > 3 {
+		return nil, fmt.Errorf("%w: %q", ErrorInfluxFormat, line)
+	}
+
+	var ts *int64
+	if len(nonEmpty) == 3 {
+		raw, err := strconv.ParseInt(nonEmpty[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad timestamp in %q", ErrorInfluxFormat, line)
+		}
+		ms := time.Unix(0, raw*int64(precision)).UnixMilli()
+		ts = &ms
+	}
+
+	seriesParts := splitUnescaped(nonEmpty[0], ',', false, 0)
+	measurement := influxUnescaper.Replace(seriesParts[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("%w: empty measurement in %q", ErrorInfluxFormat, line)
+	}
+
+	tags := make(map[string]string, len(seriesParts)-1)
+	tagKeys := make([]string, 0, len(seriesParts)-1)
+	for _, tag := range seriesParts[1:] {
+		kv := splitUnescaped(tag, '=', false, 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("%w: bad tag %q", ErrorInfluxFormat, tag)
+		}
+		key := influxUnescaper.Replace(kv[0])
+		tags[key] = influxUnescaper.Replace(kv[1])
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+
+	prefix := []string{measurement}
+	for _, key := range tagKeys {
+		prefix = append(prefix, tags[key])
+	}
+
+	var result model.MetricsPack
+	for _, field := range splitUnescaped(nonEmpty[1], ',', true, 0) {
+		kv := splitUnescaped(field, '=', true, 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("%w: bad field %q", ErrorInfluxFormat, field)
+		}
+
+		name := strings.Join(append(prefix, influxUnescaper.Replace(kv[0])), ".")
+		raw := kv[1]
+
+		switch {
+		case strings.HasPrefix(raw, `"`):
+			continue
+
+		case raw == "t" || raw == "T" || raw == "true" || raw == "True" || raw == "TRUE",
+			raw == "f" || raw == "F" || raw == "false" || raw == "False" || raw == "FALSE":
+			continue
+
+		case strings.HasSuffix(raw, "i"):
+			integer, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad integer field %q", ErrorInfluxFormat, field)
+			}
+			value := float64(integer)
+			result = append(result, model.Metric{ID: name, MType: "gauge", Value: &value, TS: ts})
+
+		case strings.HasSuffix(raw, "u"):
+			unsigned, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad unsigned field %q", ErrorInfluxFormat, field)
+			}
+			value := float64(unsigned)
+			result = append(result, model.Metric{ID: name, MType: "gauge", Value: &value, TS: ts})
+
+		default:
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad float field %q", ErrorInfluxFormat, field)
+			}
+			result = append(result, model.Metric{ID: name, MType: "gauge", Value: &value, TS: ts})
+		}
+	}
+
+	return result, nil
+}
+
+// ParseInfluxLines разбирает тело запроса построчно, пропуская пустые строки и комментарии
+func ParseInfluxLines(body string, precision time.Duration) (model.MetricsPack, error) {
+	var result model.MetricsPack
+
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		metrics, err := ParseInfluxLine(line, precision)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		result = append(result, metrics...)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,249 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+	"go.uber.org/zap"
+)
+
+// maxStatsdPacketSize — максимальный размер UDP датаграммы
+const maxStatsdPacketSize = 65535
+
+var (
+	ErrorStatsdFormat        = errors.New("invalid statsd line")
+	ErrorStatsdFlushInterval = errors.New("statsd flush interval must be positive")
+)
+
+// StatsdMetric — одна разобранная строка формата name:value|type[|@rate]
+type StatsdMetric struct {
+	Name       string
+	MType      string // "counter" или "gauge"
+	Value      float64
+	SampleRate float64
+	IsDelta    bool // для gauge: значение со знаком +/- изменяет текущее значение
+}
+
+func ParseStatsdLine(line string) (StatsdMetric, error) {
+	var metric StatsdMetric
+
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return metric, fmt.Errorf("%w: %q", ErrorStatsdFormat, line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return metric, fmt.Errorf("%w: %q", ErrorStatsdFormat, line)
+	}
+
+	rawValue := parts[0]
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return metric, fmt.Errorf("%w: bad value in %q", ErrorStatsdFormat, line)
+	}
+
+	metric.Name = name
+	metric.Value = value
+	metric.SampleRate = 1
+
+	switch parts[1] {
+	case "c":
+		metric.MType = "counter"
+	case "g":
+		metric.MType = "gauge"
+		metric.IsDelta = strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")
+	default:
+		return metric, fmt.Errorf("%w: unsupported type %q", ErrorStatsdFormat, parts[1])
+	}
+
+	for _, part := range parts[2:] {
+		if !strings.HasPrefix(part, "@") {
+			continue // теги (#tag) и прочие расширения игнорируем
+		}
+		rate, err := strconv.ParseFloat(part[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return metric, fmt.Errorf("%w: bad sample rate in %q", ErrorStatsdFormat, line)
+		}
+		metric.SampleRate = rate
+	}
+
+	return metric, nil
+}
+
+/*
+StatsdServer принимает метрики в формате StatsD по UDP, агрегирует их в памяти
+и раз в flushInterval сохраняет пачкой через MetricService.AddMetricsPack:
+счётчики суммируются с учётом sample rate, для gauge сохраняется последнее значение.
+Изменения gauge (+N/-N) без значения в текущем окне копятся в gaugeDeltas и прибавляются
+к сохранённому значению при сбросе, чтобы не обращаться к хранилищу под блокировкой.
+Дробная часть счётчика, которая появляется из-за sample rate, переносится в следующее окно.
+*/
+type StatsdServer struct {
+	addr          string
+	flushInterval time.Duration
+	services      *service.MetricService
+	logger        *zap.SugaredLogger
+
+	flushMx     sync.Mutex // сброс вызывается по таймеру и при остановке, а gauge-изменения читают хранилище
+	mx          sync.Mutex
+	counters    map[string]float64
+	gauges      map[string]float64
+	gaugeDeltas map[string]float64
+}
+
+func NewStatsdServer(addr string, flushInterval time.Duration, services *service.MetricService, logger *zap.SugaredLogger) (*StatsdServer, error) {
+	if flushInterval <= 0 {
+		return nil, ErrorStatsdFlushInterval
+	}
+
+	return &StatsdServer{
+		addr:          addr,
+		flushInterval: flushInterval,
+		services:      services,
+		logger:        logger,
+		counters:      make(map[string]float64),
+		gauges:        make(map[string]float64),
+		gaugeDeltas:   make(map[string]float64),
+	}, nil
+}
+
+// Run слушает UDP порт до отмены контекста, после чего сбрасывает накопленные метрики
+func (s *StatsdServer) Run(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	flushTicker := time.NewTicker(s.flushInterval)
+	defer flushTicker.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-flushTicker.C:
+				s.Flush()
+			}
+		}
+	}()
+
+	s.logger.Infof("StatsD listener started on %s", s.addr)
+
+	buf := make([]byte, maxStatsdPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				s.Flush()
+				return nil
+			}
+			s.logger.Errorf("statsd read error: %v", err)
+			continue
+		}
+		s.HandlePacket(buf[:n])
+	}
+}
+
+func (s *StatsdServer) HandlePacket(packet []byte) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		metric, err := ParseStatsdLine(line)
+		if err != nil {
+			s.logger.Debug(err)
+			continue
+		}
+
+		switch metric.MType {
+		case "counter":
+			s.counters[metric.Name] += metric.Value / metric.SampleRate
+
+		case "gauge":
+			if !metric.IsDelta {
+				s.gauges[metric.Name] = metric.Value
+				delete(s.gaugeDeltas, metric.Name)
+				continue
+			}
+
+			if current, ok := s.gauges[metric.Name]; ok {
+				s.gauges[metric.Name] = current + metric.Value
+				continue
+			}
+			s.gaugeDeltas[metric.Name] += metric.Value
+		}
+	}
+}
+
+// Flush сохраняет накопленные с прошлого сброса метрики одной пачкой
+func (s *StatsdServer) Flush() {
+	s.flushMx.Lock()
+	defer s.flushMx.Unlock()
+
+	s.mx.Lock()
+	pack := make(model.MetricsPack, 0, len(s.counters)+len(s.gauges))
+	remainders := make(map[string]float64)
+
+	for name, value := range s.counters {
+		delta := int64(value)
+		remainder := value - float64(delta)
+		if remainder != 0 {
+			remainders[name] = remainder
+			if delta == 0 {
+				continue
+			}
+		}
+		pack = append(pack, model.Metric{ID: name, MType: "counter", Delta: &delta})
+	}
+	for name, value := range s.gauges {
+		pack = append(pack, model.Metric{ID: name, MType: "gauge", Value: &value})
+	}
+
+	gaugeDeltas := s.gaugeDeltas
+
+	s.counters = remainders
+	s.gauges = make(map[string]float64)
+	s.gaugeDeltas = make(map[string]float64)
+	s.mx.Unlock()
+
+	for name, delta := range gaugeDeltas {
+		stored, err := s.services.GetGaugeItem(name)
+		if err != nil && !errors.Is(err, storage.ErrorGaugeNotFound) {
+			s.logger.Errorf("statsd gauge delta for %s: %v", name, err)
+			continue
+		}
+
+		value := float64(stored) + delta
+		pack = append(pack, model.Metric{ID: name, MType: "gauge", Value: &value})
+	}
+
+	if len(pack) == 0 {
+		return
+	}
+
+	s.logger.Debugf("statsd flush: %d metrics", len(pack))
+	if err := s.services.AddMetricsPack(pack); err != nil {
+		s.logger.Errorf("statsd flush error: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/ingest"
+)
+
+func Test_MatchGraphitePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"servers.*.cpu.total", "servers.web1.cpu.total", true},
+		{"servers.*.cpu.total", "servers.web1.cpu", false},
+		{"servers.*.cpu.total", "servers.web1.db.cpu.total", false},
+		{"*.errors", "api.errors", true},
+		{"jobs.*.runs", "jobs.backup.runs", true},
+		{"jobs.web?.runs", "jobs.web1.runs", true},
+		{"jobs.[", "jobs.x", false},
+		{"exact.path", "exact.path", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+"/"+test.path, func(t *testing.T) {
+			if got := ingest.MatchGraphitePattern(test.pattern, test.path); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func Test_ParseGraphiteLine(t *testing.T) {
+	counterPatterns := []string{"*.errors"}
+
+	tests := []struct {
+		name    string
+		line    string
+		mType   string
+		value   float64
+		ts      int64 // 0 - время не передано
+		wantErr bool
+	}{
+		{name: "gauge", line: "servers.web1.cpu 12.5", mType: "gauge", value: 12.5},
+		{name: "timestamp", line: "servers.web1.cpu 12.5 1700000000", mType: "gauge", value: 12.5, ts: 1700000000000},
+		{name: "fractional timestamp", line: "servers.web1.cpu 1 1700000000.5", mType: "gauge", value: 1, ts: 1700000000500},
+		{name: "now timestamp -1", line: "servers.web1.cpu 1 -1", mType: "gauge", value: 1},
+		{name: "now timestamp N", line: "servers.web1.cpu 1 N", mType: "gauge", value: 1},
+		{name: "counter pattern", line: "api.errors 2.6", mType: "counter", value: 3},
+		{name: "missing value", line: "servers.web1.cpu", wantErr: true},
+		{name: "extra fields", line: "servers.web1.cpu 1 1700000000 x", wantErr: true},
+		{name: "bad value", line: "servers.web1.cpu abc", wantErr: true},
+		{name: "nan value", line: "servers.web1.cpu NaN", wantErr: true},
+		{name: "bad timestamp", line: "servers.web1.cpu 1 yesterday", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metric, err := ingest.ParseGraphiteLine(test.line, counterPatterns)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %q", test.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if metric.MType != test.mType {
+				t.Errorf("type: got %s, want %s", metric.MType, test.mType)
+			}
+
+			var value float64
+			switch metric.MType {
+			case "gauge":
+				value = *metric.Value
+			case "counter":
+				value = float64(*metric.Delta)
+			}
+			if value != test.value {
+				t.Errorf("value: got %v, want %v", value, test.value)
+			}
+
+			switch {
+			case test.ts == 0 && metric.TS != nil:
+				t.Errorf("ts: got %d, want none", *metric.TS)
+			case test.ts != 0 && (metric.TS == nil || *metric.TS != test.ts):
+				t.Errorf("ts: got %v, want %d", metric.TS, test.ts)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/ingest"
+)
+
+func Test_ParseInfluxLine(t *testing.T) {
+	type want struct {
+		id    string
+		mType string
+		value float64
+	}
+	tests := []struct {
+		name    string
+		line    string
+		want    []want
+		ts      int64
+		wantErr bool
+	}{
+		{
+			name: "tags and fields",
+			line: `cpu,host=web1,cpu=cpu0 usage_idle=98.5,ticks=10i 1700000000000000000`,
+			want: []want{
+				{id: "cpu.cpu0.web1.usage_idle", mType: "gauge", value: 98.5},
+				{id: "cpu.cpu0.web1.ticks", mType: "gauge", value: 10},
+			},
+			ts: 1700000000000,
+		},
+		{
+			name: "integer fields are gauges",
+			line: `net,interface=eth0 bytes_recv=18446744073709551615u,drops=-3i`,
+			want: []want{
+				{id: "net.eth0.bytes_recv", mType: "gauge", value: 18446744073709551615},
+				{id: "net.eth0.drops", mType: "gauge", value: -3},
+			},
+		},
+		{
+			name: "escaped and string fields",
+			line: `disk\ io,path=/var used=1.5,label="a b, c",ok=true`,
+			want: []want{
+				{id: "disk io./var.used", mType: "gauge", value: 1.5},
+			},
+		},
+		{
+			name:    "no fields",
+			line:    `cpu,host=web1`,
+			wantErr: true,
+		},
+		{
+			name:    "bad integer",
+			line:    `cpu value=1.5i`,
+			wantErr: true,
+		},
+		{
+			name:    "negative unsigned",
+			line:    `cpu value=-1u`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ingest.ParseInfluxLine(test.line, time.Nanosecond)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %q", test.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d metrics, want %d", len(got), len(test.want))
+			}
+
+			for i, w := range test.want {
+				m := got[i]
+				if m.ID != w.id || m.MType != w.mType {
+					t.Errorf("metric %d = %s/%s, want %s/%s", i, m.ID, m.MType, w.id, w.mType)
+				}
+				if m.Value == nil || *m.Value != w.value {
+					t.Errorf("metric %d value = %v, want %v", i, m.Value, w.value)
+				}
+				if test.ts != 0 && (m.TS == nil || *m.TS != test.ts) {
+					t.Errorf("metric %d ts = %v, want %v", i, m.TS, test.ts)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/ingest"
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+	"go.uber.org/zap"
+)
+
+func Test_ParseStatsdLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    ingest.StatsdMetric
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			line: "requests:1|c",
+			want: ingest.StatsdMetric{Name: "requests", MType: "counter", Value: 1, SampleRate: 1},
+		},
+		{
+			name: "counter with sample rate",
+			line: "requests:2|c|@0.5",
+			want: ingest.StatsdMetric{Name: "requests", MType: "counter", Value: 2, SampleRate: 0.5},
+		},
+		{
+			name: "gauge",
+			line: "temperature:3.2|g",
+			want: ingest.StatsdMetric{Name: "temperature", MType: "gauge", Value: 3.2, SampleRate: 1},
+		},
+		{
+			name: "gauge delta",
+			line: "queue:-4|g",
+			want: ingest.StatsdMetric{Name: "queue", MType: "gauge", Value: -4, SampleRate: 1, IsDelta: true},
+		},
+		{
+			name:    "unsupported type",
+			line:    "latency:12|ms",
+			wantErr: true,
+		},
+		{
+			name:    "bad value",
+			line:    "requests:abc|c",
+			wantErr: true,
+		},
+		{
+			name:    "bad sample rate",
+			line:    "requests:1|c|@2",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ingest.ParseStatsdLine(test.line)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %q", test.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func Test_StatsdGaugeDeltaFlush(t *testing.T) {
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.AddGaugeItem("queue", 10)
+
+	statsd, err := ingest.NewStatsdServer("", time.Second, services, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// изменение без значения в окне прибавляется к сохранённому значению при сбросе
+	statsd.HandlePacket([]byte("queue:-4|g\nqueue:+1|g\nhits:2|c|@0.5"))
+	statsd.Flush()
+
+	if got, _ := services.GetGaugeItem("queue"); got != 7 {
+		t.Errorf("queue: got %v, want 7", got)
+	}
+	if got, _ := services.GetCounterItem("hits"); got != 4 {
+		t.Errorf("hits: got %v, want 4", got)
+	}
+
+	// абсолютное значение отменяет накопленные изменения
+	statsd.HandlePacket([]byte("queue:+5|g\nqueue:3|g\nqueue:+1|g"))
+	statsd.Flush()
+
+	if got, _ := services.GetGaugeItem("queue"); got != 4 {
+		t.Errorf("queue: got %v, want 4", got)
+	}
+}
+
+func Test_StatsdSampleRateRemainder(t *testing.T) {
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statsd, err := ingest.NewStatsdServer("", time.Second, services, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// каждая строка с @0.4 даёт 2.5, дробная часть переносится в следующий сброс
+	steps := []struct {
+		packet string
+		want   model.Counter
+	}{
+		{packet: "hits:1|c|@0.4", want: 2},
+		{packet: "hits:1|c|@0.4", want: 5},
+		{packet: "hits:1|c|@0.4", want: 7},
+		{packet: "", want: 7},
+		{packet: "hits:1|c|@0.4", want: 10},
+	}
+	for i, step := range steps {
+		statsd.HandlePacket([]byte(step.packet))
+		statsd.Flush()
+
+		if got, _ := services.GetCounterItem("hits"); got != step.want {
+			t.Errorf("flush %d: got %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func Test_StatsdFlushInterval(t *testing.T) {
+	_, err := ingest.NewStatsdServer("", 0, nil, zap.NewNop().Sugar())
+	if !errors.Is(err, ingest.ErrorStatsdFlushInterval) {
+		t.Errorf("got %v, want %v", err, ingest.ErrorStatsdFlushInterval)
+	}
+}
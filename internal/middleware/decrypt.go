@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bbquite/mca-server/internal/utils"
+	"go.uber.org/zap"
+)
+
+// EncryptedKeyHeader содержит зашифрованный RSA сессионный ключ тела запроса в base64
+const EncryptedKeyHeader = "X-Encrypted-Key"
+
+// decryptedKey отмечает в контексте запрос, тело которого было расшифровано DecryptMiddleware
+type decryptedKey struct{}
+
+/*
+DecryptMiddleware расшифровывает тело запроса, зашифрованное агентом (см. utils.EncryptHybrid).
+Запросы без заголовка X-Encrypted-Key пропускаются без изменений, обязательность шифрования
+для отдельных маршрутов проверяет RequireEncryptionMiddleware.
+Должен подключаться до GzipMiddleware, так как агент сжимает данные перед шифрованием.
+*/
+func DecryptMiddleware(privateKey *rsa.PrivateKey, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headerKey := r.Header.Get(EncryptedKeyHeader)
+			if headerKey == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if privateKey == nil {
+				http.Error(w, "encrypted requests are not supported", http.StatusBadRequest)
+				return
+			}
+
+			encryptedKey, err := base64.StdEncoding.DecodeString(headerKey)
+			if err != nil {
+				http.Error(w, "malformed "+EncryptedKeyHeader+" header", http.StatusBadRequest)
+				return
+			}
+
+			payload, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			body, err := utils.DecryptHybrid(privateKey, encryptedKey, payload)
+			if err != nil {
+				logger.Infof("request %s %s rejected: decrypt error: %v", r.Method, r.RequestURI, err)
+				http.Error(w, "unable to decrypt request body", http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			r.Header.Del(EncryptedKeyHeader)
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decryptedKey{}, true)))
+		})
+	}
+}
+
+// RequireEncryptionMiddleware при заданном приватном ключе отклоняет с кодом 400 запросы,
+// тело которых не было зашифровано агентом. Подключается после DecryptMiddleware
+func RequireEncryptionMiddleware(privateKey *rsa.PrivateKey, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if privateKey == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if decrypted, _ := r.Context().Value(decryptedKey{}).(bool); !decrypted {
+				logger.Infof("request %s %s rejected: body is not encrypted", r.Method, r.RequestURI)
+				http.Error(w, "request body must be encrypted", http.StatusBadRequest)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
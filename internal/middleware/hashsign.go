@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bbquite/mca-server/internal/utils"
+	"go.uber.org/zap"
+)
+
+const HashSignHeader = "HashSHA256"
+
+// signWriter накапливает ответ обработчика, чтобы подписать его целиком перед отправкой
+type signWriter struct {
+	w      http.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (s *signWriter) Header() http.Header {
+	return s.w.Header()
+}
+
+func (s *signWriter) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *signWriter) WriteHeader(statusCode int) {
+	if s.status == 0 {
+		s.status = statusCode
+	}
+}
+
+func (s *signWriter) flush(shaKey string) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	s.w.Header().Set(HashSignHeader, hex.EncodeToString(utils.MakeHMACSign(shaKey, s.buf.Bytes())))
+	s.w.WriteHeader(s.status)
+	s.w.Write(s.buf.Bytes())
+}
+
+/*
+HashSignMiddleware проверяет подпись HMAC-SHA256 тела запроса из заголовка HashSHA256
+и подписывает тем же ключом тело ответа. Запросы без подписи или с некорректной подписью
+отклоняются с кодом 400, с неверной подписью - 401.
+Заголовки X-Signature-Timestamp и X-Signature-Nonce подписываются вместе с методом, URI и телом запроса
+(см. utils.SignedPayload), при заданном replayGuard повторные запросы отклоняются.
+Подпись только тела запроса без этих заголовков принимается от старых агентов лишь при legacyBodySign.
+Должен подключаться после GzipMiddleware, так как подписываются несжатые данные.
+*/
+func HashSignMiddleware(shaKey string, replayGuard *ReplayGuard, legacyBodySign bool, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if shaKey == "" {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			headerSign := r.Header.Get(HashSignHeader)
+			if headerSign == "" {
+				logger.Infof("request %s %s rejected: missing %s", r.Method, r.RequestURI, HashSignHeader)
+				http.Error(w, "missing "+HashSignHeader+" header", http.StatusBadRequest)
+				return
+			}
+
+			sign, err := hex.DecodeString(headerSign)
+			if err != nil {
+				logger.Infof("request %s %s rejected: malformed %s", r.Method, r.RequestURI, HashSignHeader)
+				http.Error(w, "malformed "+HashSignHeader+" header", http.StatusBadRequest)
+				return
+			}
+
+			timestamp := r.Header.Get(TimestampHeader)
+			nonce := r.Header.Get(NonceHeader)
+
+			// подпись только тела без метода и URI допускается для старых агентов, но не для пустого тела:
+			// такая подпись одинакова для всех маршрутов вида /update/{type}/{name}/{value}
+			if timestamp == "" && nonce == "" && (!legacyBodySign || len(body) == 0) {
+				logger.Infof("request %s %s rejected: %v", r.Method, r.RequestURI, ErrorReplayHeaders)
+				http.Error(w, ErrorReplayHeaders.Error(), http.StatusBadRequest)
+				return
+			}
+
+			signedData := body
+			if timestamp != "" || nonce != "" {
+				signedData = utils.SignedPayload(r.Method, r.RequestURI, timestamp, nonce, body)
+			}
+
+			if !utils.CheckHMACEqual(shaKey, sign, signedData) {
+				logger.Infof("request %s %s rejected: invalid signature", r.Method, r.RequestURI)
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			if replayGuard != nil {
+				err = replayGuard.Check(timestamp, nonce)
+				if err != nil {
+					logger.Infof("request %s %s rejected: %v", r.Method, r.RequestURI, err)
+
+					switch {
+					case errors.Is(err, ErrorReplayHeaders):
+						http.Error(w, err.Error(), http.StatusBadRequest)
+					case errors.Is(err, ErrorNonceCacheFull):
+						http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					default:
+						http.Error(w, err.Error(), http.StatusUnauthorized)
+					}
+					return
+				}
+			}
+
+			sw := &signWriter{w: w}
+			h.ServeHTTP(sw, r)
+			sw.flush(shaKey)
+		})
+	}
+}
@@ -34,6 +34,28 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// redactedHeaders - заголовки с токенами и подписями, значения которых не пишутся в лог
+var redactedHeaders = []string{"Authorization", HashSignHeader, NonceHeader}
+
+// redactHeaders возвращает копию заголовков запроса для лога без секретных значений
+func redactHeaders(header http.Header) http.Header {
+	result := header.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := result[http.CanonicalHeaderKey(name)]; ok {
+			result.Set(name, "[REDACTED]")
+		}
+	}
+	return result
+}
+
+// ClientCN возвращает CommonName проверенного клиентского сертификата (mTLS) либо пустую строку
+func ClientCN(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
 func RequestsLoggingMiddleware(logger *zap.SugaredLogger) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -58,7 +80,8 @@ func RequestsLoggingMiddleware(logger *zap.SugaredLogger) func(http.Handler) htt
 				"uri", r.RequestURI,
 				"size", responseData.size,
 				"duration", duration,
-				"\nheaders", r.Header,
+				"client", ClientCN(r),
+				"\nheaders", redactHeaders(r.Header),
 			)
 		})
 	}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Signature-Timestamp"
+	NonceHeader     = "X-Signature-Nonce"
+
+	maxNonceLength = 64
+)
+
+var (
+	ErrorReplayHeaders   = errors.New("missing or malformed timestamp/nonce headers")
+	ErrorReplayExpired   = errors.New("request timestamp outside of allowed window")
+	ErrorReplayDuplicate = errors.New("nonce already used")
+	ErrorNonceCacheFull  = errors.New("nonce cache is full")
+)
+
+/*
+ReplayGuard отклоняет повторно отправленные подписанные запросы: timestamp запроса
+должен отличаться от текущего времени не более чем на window, а nonce не должен
+встречаться повторно в течение этого окна. Количество хранимых nonce ограничено maxNonces.
+*/
+type ReplayGuard struct {
+	window    time.Duration
+	maxNonces int
+
+	mx     sync.Mutex
+	nonces map[string]time.Time // nonce -> время, после которого его можно забыть
+}
+
+func NewReplayGuard(window time.Duration, maxNonces int) *ReplayGuard {
+	return &ReplayGuard{
+		window:    window,
+		maxNonces: maxNonces,
+		nonces:    make(map[string]time.Time),
+	}
+}
+
+func (g *ReplayGuard) Check(timestamp string, nonce string) error {
+	if timestamp == "" || nonce == "" || len(nonce) > maxNonceLength {
+		return ErrorReplayHeaders
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrorReplayHeaders
+	}
+
+	now := time.Now()
+	requestTime := time.Unix(seconds, 0)
+	if requestTime.Before(now.Add(-g.window)) || requestTime.After(now.Add(g.window)) {
+		return ErrorReplayExpired
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if expire, ok := g.nonces[nonce]; ok && expire.After(now) {
+		return ErrorReplayDuplicate
+	}
+
+	if len(g.nonces) >= g.maxNonces {
+		for key, expire := range g.nonces {
+			if !expire.After(now) {
+				delete(g.nonces, key)
+			}
+		}
+		if len(g.nonces) >= g.maxNonces {
+			return ErrorNonceCacheFull
+		}
+	}
+
+	// запрос с этим nonce будет отклонён по времени не позже requestTime+window
+	g.nonces[nonce] = requestTime.Add(g.window)
+	return nil
+}
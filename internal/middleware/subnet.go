@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+const RealIPHeader = "X-Real-IP"
+
+// TrustedSubnetMiddleware пропускает только запросы, у которых IP из заголовка X-Real-IP
+// входит в trustedSubnet. При trustedSubnet = nil проверка отключена
+func TrustedSubnetMiddleware(trustedSubnet *net.IPNet, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if trustedSubnet == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := net.ParseIP(r.Header.Get(RealIPHeader))
+			if ip == nil || !trustedSubnet.Contains(ip) {
+				logger.Infof("request %s %s rejected: %s=%q is not in trusted subnet %s",
+					r.Method, r.RequestURI, RealIPHeader, r.Header.Get(RealIPHeader), trustedSubnet)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/middleware"
+	"github.com/bbquite/mca-server/internal/utils"
+	"go.uber.org/zap"
+)
+
+func Test_RequireEncryptionMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zap.NewNop().Sugar()
+	var gotBody []byte
+	handler := middleware.DecryptMiddleware(key, logger)(
+		middleware.RequireEncryptionMiddleware(key, logger)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			}),
+		),
+	)
+
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+
+	t.Run("plaintext rejected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("encrypted accepted", func(t *testing.T) {
+		encryptedKey, payload, err := utils.EncryptHybrid(&key.PublicKey, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(payload))
+		request.Header.Set(middleware.EncryptedKeyHeader, base64.StdEncoding.EncodeToString(encryptedKey))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+		}
+		if !bytes.Equal(gotBody, body) {
+			t.Errorf("got body %q, want %q", gotBody, body)
+		}
+	})
+}
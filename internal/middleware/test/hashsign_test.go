@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/middleware"
+	"github.com/bbquite/mca-server/internal/utils"
+	"go.uber.org/zap"
+)
+
+const testKey = "secret"
+
+// signedRequest создаёт запрос method uri с подписью, сделанной для signedMethod signedURI
+func signedRequest(method string, uri string, signedMethod string, signedURI string, nonce string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sign := utils.MakeHMACSign(testKey, utils.SignedPayload(signedMethod, signedURI, timestamp, nonce, nil))
+
+	request := httptest.NewRequest(method, uri, nil)
+	request.Header.Set(middleware.TimestampHeader, timestamp)
+	request.Header.Set(middleware.NonceHeader, nonce)
+	request.Header.Set(middleware.HashSignHeader, hex.EncodeToString(sign))
+	return request
+}
+
+func Test_HashSignMiddlewareBindsRoute(t *testing.T) {
+	handler := middleware.HashSignMiddleware(testKey, nil, false, zap.NewNop().Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	tests := []struct {
+		name         string
+		method       string
+		uri          string
+		signedMethod string
+		signedURI    string
+		want         int
+	}{
+		{
+			name:         "same route",
+			method:       http.MethodPost,
+			uri:          "/update/gauge/Alloc/1",
+			signedMethod: http.MethodPost,
+			signedURI:    "/update/gauge/Alloc/1",
+			want:         http.StatusOK,
+		},
+		{
+			name:         "signature from another path",
+			method:       http.MethodPost,
+			uri:          "/update/gauge/Alloc/1000",
+			signedMethod: http.MethodPost,
+			signedURI:    "/update/gauge/Alloc/1",
+			want:         http.StatusUnauthorized,
+		},
+		{
+			name:         "signature from another method",
+			method:       http.MethodGet,
+			uri:          "/value/gauge/Alloc",
+			signedMethod: http.MethodPost,
+			signedURI:    "/value/gauge/Alloc",
+			want:         http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := signedRequest(test.method, test.uri, test.signedMethod, test.signedURI, "nonce")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			if w.Code != test.want {
+				t.Errorf("got %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func Test_HashSignMiddlewareLegacyBodySign(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		legacy bool
+		body   string
+		want   int
+	}{
+		{name: "rejected by default", body: `{"id":"Alloc","type":"gauge","value":1}`, want: http.StatusBadRequest},
+		{name: "accepted with legacy flag", legacy: true, body: `{"id":"Alloc","type":"gauge","value":1}`, want: http.StatusOK},
+		{name: "empty body by default", want: http.StatusBadRequest},
+		{name: "empty body with legacy flag", legacy: true, want: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := middleware.HashSignMiddleware(testKey, nil, test.legacy, zap.NewNop().Sugar())(ok)
+
+			request := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(test.body))
+			request.Header.Set(middleware.HashSignHeader, hex.EncodeToString(utils.MakeHMACSign(testKey, []byte(test.body))))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			if w.Code != test.want {
+				t.Errorf("got %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_RequestsLoggingRedactsSecrets(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	handler := middleware.RequestsLoggingMiddleware(zap.New(core).Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+	request.Header.Set("Authorization", "Bearer bearer-token")
+	request.Header.Set(middleware.HashSignHeader, "signature-value")
+	request.Header.Set(middleware.NonceHeader, "nonce-value")
+	request.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d log entries, want 1", len(entries))
+	}
+	message := entries[0].Message
+
+	for _, secret := range []string{"bearer-token", "signature-value", "nonce-value"} {
+		if strings.Contains(message, secret) {
+			t.Errorf("%q leaked in log: %s", secret, message)
+		}
+	}
+	if !strings.Contains(message, "application/json") {
+		t.Errorf("other headers must be logged: %s", message)
+	}
+	if request.Header.Get("Authorization") != "Bearer bearer-token" {
+		t.Error("request headers were modified")
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bbquite/mca-server/internal/auth"
+	"go.uber.org/zap"
+)
+
+// TokenAuthMiddleware требует заголовок "Authorization: Bearer <token>" с токеном, у которого есть scope.
+// При registry = nil проверка отключена
+func TokenAuthMiddleware(registry *auth.TokenRegistry, scope string, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if registry == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || bearer == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mca-server"`)
+				http.Error(w, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			token, ok := registry.Lookup(bearer)
+			if !ok {
+				logger.Infof("request %s %s rejected: unknown token", r.Method, r.RequestURI)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mca-server", error="invalid_token"`)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			if !token.HasScope(scope) {
+				logger.Infof("request %s %s rejected: token %q has no scope %s", r.Method, r.RequestURI, token.Name, scope)
+				http.Error(w, "insufficient scope", http.StatusForbidden)
+				return
+			}
+
+			logger.Debugf("request %s %s authorized for %q", r.Method, r.RequestURI, token.Name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Gauge float64
 type Counter int64
 
@@ -8,10 +10,56 @@ type Metric struct {
 	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
 	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	TS    *int64   `json:"ts,omitempty"`    // время измерения в unix миллисекундах, если не задано - время получения
 }
 
 type MetricsPack []Metric
 
+// GaugeSample хранит значение gauge-метрики на момент его получения
+type GaugeSample struct {
+	Timestamp time.Time `json:"ts"`
+	Value     Gauge     `json:"value"`
+}
+
+// CounterSample хранит накопленное значение counter-метрики после применения очередной дельты
+type CounterSample struct {
+	Timestamp time.Time `json:"ts"`
+	Value     Counter   `json:"value"`
+}
+
+/*
+MetricHistory - история значений инмемори хранилища для сохранения в файл.
+В Truncated* перечислены метрики, у которых начало истории отброшено из-за ограничения размера
+*/
+type MetricHistory struct {
+	Gauges            map[string][]GaugeSample   `json:"gauges,omitempty"`
+	Counters          map[string][]CounterSample `json:"counters,omitempty"`
+	TruncatedGauges   []string                   `json:"truncated_gauges,omitempty"`
+	TruncatedCounters []string                   `json:"truncated_counters,omitempty"`
+}
+
+// RangePoint — агрегированные значения метрики в пределах одного интервала step
+type RangePoint struct {
+	Timestamp time.Time `json:"ts"`
+	Count     int       `json:"count"`
+	Avg       float64   `json:"avg"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Sum       float64   `json:"sum"`
+	Last      float64   `json:"last"`
+	Rate      *float64  `json:"rate,omitempty"`     // только для counter, прирост в секунду
+	Increase  *float64  `json:"increase,omitempty"` // только для counter, прирост за интервал
+}
+
+type RangeResult struct {
+	ID     string       `json:"id"`
+	MType  string       `json:"type"`
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Step   string       `json:"step"`
+	Points []RangePoint `json:"points"`
+}
+
 // CREATE TYPE metric_type AS ENUM (
 //     'GAUGE',
 //     'COUNTER'
@@ -23,3 +71,11 @@ type MetricsPack []Metric
 //     delta integer,
 //     value double precision
 // );
+// create table metric_samples (
+// 	id bigserial PRIMARY KEY,
+// 	metric_type metric_type not null,
+// 	metric_name varchar(55) not null,
+// 	delta bigint,
+// 	value double precision,
+// 	created_at timestamptz not null default now()
+// );
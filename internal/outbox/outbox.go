@@ -0,0 +1,351 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+// DropPolicy определяет, какие пачки удаляются при переполнении очереди
+type DropPolicy string
+
+const (
+	DropOldest DropPolicy = "oldest" // освободить место, удалив самые старые пачки
+	DropNewest DropPolicy = "newest" // не принимать новые пачки, пока очередь заполнена
+)
+
+const (
+	batchExt    = ".json"
+	rejectedDir = "rejected" // пачки, которые сервер отклонил окончательно, для ручного разбора
+)
+
+var (
+	ErrorFull          = errors.New("outbox is full")
+	ErrorBatchTooLarge = errors.New("batch exceeds outbox max size")
+	ErrorDropPolicy    = errors.New("unknown outbox drop policy")
+)
+
+func ParseDropPolicy(value string) (DropPolicy, error) {
+	switch policy := DropPolicy(value); policy {
+	case DropOldest, DropNewest:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrorDropPolicy, value)
+	}
+}
+
+type batchFile struct {
+	name    string
+	created time.Time
+	size    int64
+}
+
+/*
+Outbox - очередь неотправленных пачек метрик на диске.
+Каждая пачка хранится в отдельном файле <unix nano>-<seq>.json в каталоге dir,
+поэтому очередь переживает перезапуск агента и читается в порядке добавления.
+Общий размер файлов вместе с отклонёнными пачками в каталоге rejected ограничен maxSize:
+при нехватке места сначала удаляются самые старые отклонённые пачки. Пачки старше maxAge
+удаляются и из очереди, и из rejected.
+*/
+type Outbox struct {
+	mx      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	policy  DropPolicy
+	seq     uint64
+	batches []batchFile
+	size    int64
+
+	rejected     []batchFile // пачки в каталоге rejected, по возрастанию времени создания
+	rejectedSize int64
+}
+
+func New(dir string, maxSize int64, maxAge time.Duration, policy DropPolicy) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{dir: dir, maxSize: maxSize, maxAge: maxAge, policy: policy}
+
+	var err error
+	o.batches, o.size, err = readBatches(dir)
+	if err != nil {
+		return nil, err
+	}
+	o.rejected, o.rejectedSize, err = readBatches(filepath.Join(dir, rejectedDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// readBatches возвращает файлы пачек каталога в порядке добавления и их общий размер
+func readBatches(dir string) ([]batchFile, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var batches []batchFile
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), batchExt) {
+			continue
+		}
+
+		created, ok := parseBatchTime(entry.Name())
+		if !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		batches = append(batches, batchFile{name: entry.Name(), created: created, size: info.Size()})
+		size += info.Size()
+	}
+
+	sort.Slice(batches, func(i, j int) bool { return batches[i].name < batches[j].name })
+	return batches, size, nil
+}
+
+// parseBatchTime достаёт время создания из имени файла пачки
+func parseBatchTime(name string) (time.Time, bool) {
+	prefix, _, _ := strings.Cut(strings.TrimSuffix(name, batchExt), "-")
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// Len возвращает количество пачек в очереди
+func (o *Outbox) Len() int {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	return len(o.batches)
+}
+
+/*
+Push сохраняет пачку в конец очереди. Если места не хватает, сначала удаляются отклонённые пачки,
+затем при политике DropOldest удаляются самые старые пачки очереди, при DropNewest возвращается ErrorFull.
+Возвращает количество удалённых пачек очереди.
+*/
+func (o *Outbox) Push(metrics model.MetricsPack) (int, error) {
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	size := int64(len(data))
+	if o.maxSize > 0 && size > o.maxSize {
+		return 0, ErrorBatchTooLarge
+	}
+
+	dropped := o.expire(time.Now())
+	for o.maxSize > 0 && o.size+o.rejectedSize+size > o.maxSize {
+		if len(o.rejected) > 0 {
+			if err := o.removeRejectedFirst(); err != nil {
+				return dropped, err
+			}
+			continue
+		}
+		if o.policy == DropNewest {
+			return dropped, ErrorFull
+		}
+		if err := o.removeFirst(); err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+
+	now := time.Now()
+	o.seq++
+	name := fmt.Sprintf("%020d-%06d%s", now.UnixNano(), o.seq%1000000, batchExt)
+
+	// запись через временный файл, чтобы при сбое не оставить в очереди обрезанную пачку
+	tmpPath := filepath.Join(o.dir, name+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return dropped, err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(o.dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return dropped, err
+	}
+
+	o.batches = append(o.batches, batchFile{name: name, created: now, size: size})
+	o.size += size
+	return dropped, nil
+}
+
+// ReplayStats - результат одного прохода Replay
+type ReplayStats struct {
+	Sent     int // отправлено и удалено из очереди
+	Expired  int // удалено по возрасту
+	Rejected int // отклонено сервером без возможности повтора и перенесено в каталог rejected
+}
+
+/*
+Replay по порядку передаёт пачки из очереди в send и удаляет успешно отправленные.
+Если ошибка send не проходит проверку retryable (например, сервер ответил 400 или 401),
+пачка переносится в подкаталог rejected и не задерживает остальные. На первой временной
+ошибке Replay останавливается, оставляя пачку в начале очереди. retryable = nil
+считает временными все ошибки.
+*/
+func (o *Outbox) Replay(send func(metrics model.MetricsPack) error, retryable func(error) bool) (ReplayStats, error) {
+	var stats ReplayStats
+	var rejectErrs []error
+
+	for {
+		o.mx.Lock()
+		stats.Expired += o.expire(time.Now())
+		if len(o.batches) == 0 {
+			o.mx.Unlock()
+			return stats, errors.Join(rejectErrs...)
+		}
+		first := o.batches[0]
+		o.mx.Unlock()
+
+		metrics, err := o.read(first.name)
+		if err != nil {
+			// повреждённую пачку не отправить никогда, поэтому она удаляется
+			o.mx.Lock()
+			o.removeByName(first.name)
+			o.mx.Unlock()
+			return stats, errors.Join(append(rejectErrs, fmt.Errorf("batch %s is corrupted and dropped: %w", first.name, err))...)
+		}
+
+		if err := send(metrics); err != nil {
+			if retryable == nil || retryable(err) {
+				return stats, errors.Join(append(rejectErrs, err)...)
+			}
+
+			o.mx.Lock()
+			moveErr := o.reject(first.name)
+			o.mx.Unlock()
+			if moveErr != nil {
+				return stats, errors.Join(append(rejectErrs, err, moveErr)...)
+			}
+
+			rejectErrs = append(rejectErrs, fmt.Errorf("batch %s rejected: %w", first.name, err))
+			stats.Rejected++
+			continue
+		}
+
+		o.mx.Lock()
+		err = o.removeByName(first.name)
+		o.mx.Unlock()
+		if err != nil {
+			return stats, errors.Join(append(rejectErrs, err)...)
+		}
+		stats.Sent++
+	}
+}
+
+// reject переносит пачку из очереди в подкаталог rejected, вызывается под o.mx
+func (o *Outbox) reject(name string) error {
+	dir := filepath.Join(o.dir, rejectedDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(o.dir, name), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+
+	for _, batch := range o.batches {
+		if batch.name == name {
+			o.rejected = append(o.rejected, batch)
+			o.rejectedSize += batch.size
+			break
+		}
+	}
+	return o.removeByName(name)
+}
+
+// removeRejectedFirst удаляет самую старую отклонённую пачку, вызывается под o.mx
+func (o *Outbox) removeRejectedFirst() error {
+	batch := o.rejected[0]
+	err := os.Remove(filepath.Join(o.dir, rejectedDir, batch.name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	o.rejected = o.rejected[1:]
+	o.rejectedSize -= batch.size
+	return nil
+}
+
+func (o *Outbox) read(name string) (model.MetricsPack, error) {
+	data, err := os.ReadFile(filepath.Join(o.dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics model.MetricsPack
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// expire удаляет пачки старше maxAge и возвращает их количество в очереди, вызывается под o.mx
+func (o *Outbox) expire(now time.Time) int {
+	if o.maxAge <= 0 {
+		return 0
+	}
+
+	for len(o.rejected) > 0 && now.Sub(o.rejected[0].created) > o.maxAge {
+		if err := o.removeRejectedFirst(); err != nil {
+			break
+		}
+	}
+
+	expired := 0
+	for len(o.batches) > 0 && now.Sub(o.batches[0].created) > o.maxAge {
+		if err := o.removeFirst(); err != nil {
+			break
+		}
+		expired++
+	}
+	return expired
+}
+
+func (o *Outbox) removeFirst() error {
+	return o.removeByName(o.batches[0].name)
+}
+
+// removeByName удаляет пачку из очереди и с диска, вызывается под o.mx
+func (o *Outbox) removeByName(name string) error {
+	for i, batch := range o.batches {
+		if batch.name != name {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(o.dir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		o.batches = append(o.batches[:i], o.batches[i+1:]...)
+		o.size -= batch.size
+		return nil
+	}
+	return nil
+}
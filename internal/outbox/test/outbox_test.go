@@ -0,0 +1,227 @@
+package outbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/outbox"
+)
+
+func pack(id string) model.MetricsPack {
+	value := 1.0
+	return model.MetricsPack{{ID: id, MType: "gauge", Value: &value}}
+}
+
+func replayIDs(t *testing.T, box *outbox.Outbox) []string {
+	t.Helper()
+
+	var ids []string
+	_, err := box.Replay(func(metrics model.MetricsPack) error {
+		ids = append(ids, metrics[0].ID)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func Test_OutboxReplayOrderAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	box, err := outbox.New(dir, 0, 0, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := box.Push(pack(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// первая отправка после "a" не удалась, "b" и "c" должны остаться в очереди
+	failure := errors.New("server unavailable")
+	stats, err := box.Replay(func(metrics model.MetricsPack) error {
+		if metrics[0].ID == "b" {
+			return failure
+		}
+		return nil
+	}, nil)
+	if !errors.Is(err, failure) || stats.Sent != 1 {
+		t.Fatalf("got sent=%d err=%v, want sent=1 err=%v", stats.Sent, err, failure)
+	}
+
+	reopened, err := outbox.New(dir, 0, 0, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := replayIDs(t, reopened)
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Errorf("got %v, want [b c]", ids)
+	}
+	if reopened.Len() != 0 {
+		t.Errorf("outbox is not empty after replay")
+	}
+}
+
+func Test_OutboxDropPolicy(t *testing.T) {
+	batchSize := int64(len(`[{"id":"a","type":"gauge","value":1}]`))
+
+	oldest, err := outbox.New(t.TempDir(), 2*batchSize, 0, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := oldest.Push(pack(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids := replayIDs(t, oldest); len(ids) != 2 || ids[0] != "b" {
+		t.Errorf("drop oldest: got %v, want [b c]", ids)
+	}
+
+	newest, err := outbox.New(t.TempDir(), 2*batchSize, 0, outbox.DropNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest.Push(pack("a"))
+	newest.Push(pack("b"))
+	if _, err := newest.Push(pack("c")); !errors.Is(err, outbox.ErrorFull) {
+		t.Errorf("drop newest: got %v, want %v", err, outbox.ErrorFull)
+	}
+}
+
+func Test_OutboxMaxAge(t *testing.T) {
+	box, err := outbox.New(t.TempDir(), 0, 10*time.Millisecond, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	box.Push(pack("a"))
+	time.Sleep(20 * time.Millisecond)
+
+	stats, err := box.Replay(func(model.MetricsPack) error { return nil }, nil)
+	if err != nil || stats.Sent != 0 || stats.Expired != 1 {
+		t.Errorf("got sent=%d expired=%d err=%v, want 0 1 nil", stats.Sent, stats.Expired, err)
+	}
+}
+
+func Test_OutboxReplaySetsAsideRejected(t *testing.T) {
+	dir := t.TempDir()
+
+	box, err := outbox.New(dir, 0, 0, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "bad", "c"} {
+		if _, err := box.Push(pack(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rejected := errors.New("400 Bad Request")
+	var sent []string
+	stats, err := box.Replay(func(metrics model.MetricsPack) error {
+		if metrics[0].ID == "bad" {
+			return rejected
+		}
+		sent = append(sent, metrics[0].ID)
+		return nil
+	}, func(err error) bool { return !errors.Is(err, rejected) })
+
+	if !errors.Is(err, rejected) {
+		t.Errorf("got err=%v, want %v", err, rejected)
+	}
+	if stats.Sent != 2 || stats.Rejected != 1 || len(sent) != 2 || sent[1] != "c" {
+		t.Errorf("got stats=%+v sent=%v, want 2 sent [a c] and 1 rejected", stats, sent)
+	}
+	if box.Len() != 0 {
+		t.Errorf("got %d pending batches, want 0", box.Len())
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "rejected"))
+	if err != nil || len(files) != 1 {
+		t.Errorf("got %d rejected files (%v), want 1", len(files), err)
+	}
+}
+
+func rejectAll(t *testing.T, box *outbox.Outbox) {
+	t.Helper()
+
+	rejected := errors.New("400 Bad Request")
+	stats, _ := box.Replay(func(model.MetricsPack) error { return rejected }, func(error) bool { return false })
+	if box.Len() != 0 || stats.Rejected == 0 {
+		t.Fatalf("got %d pending batches and stats %+v, want all rejected", box.Len(), stats)
+	}
+}
+
+func rejectedFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	files, err := os.ReadDir(filepath.Join(dir, "rejected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func Test_OutboxRejectedCountsTowardsMaxSize(t *testing.T) {
+	batchSize := int64(len(`[{"id":"a","type":"gauge","value":1}]`))
+	dir := t.TempDir()
+
+	box, err := outbox.New(dir, 3*batchSize, 0, outbox.DropNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	box.Push(pack("a"))
+	box.Push(pack("b"))
+	rejectAll(t, box)
+
+	// отклонённые пачки занимают место и удаляются раньше, чем очередь считается заполненной
+	for _, id := range []string{"c", "d"} {
+		if _, err := box.Push(pack(id)); err != nil {
+			t.Fatalf("push %s: %v", id, err)
+		}
+	}
+	if got := rejectedFiles(t, dir); got != 1 {
+		t.Errorf("got %d rejected files, want 1", got)
+	}
+
+	// размер каталога rejected учитывается и после перезапуска
+	reopened, err := outbox.New(dir, 3*batchSize, 0, outbox.DropNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Push(pack("e")); err != nil {
+		t.Fatal(err)
+	}
+	if got := rejectedFiles(t, dir); got != 0 {
+		t.Errorf("got %d rejected files after reopen, want 0", got)
+	}
+	if _, err := reopened.Push(pack("f")); !errors.Is(err, outbox.ErrorFull) {
+		t.Errorf("got %v, want %v", err, outbox.ErrorFull)
+	}
+	if ids := replayIDs(t, reopened); len(ids) != 3 || ids[0] != "c" {
+		t.Errorf("got %v, want [c d e]", ids)
+	}
+}
+
+func Test_OutboxRejectedMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	box, err := outbox.New(dir, 0, 10*time.Millisecond, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	box.Push(pack("a"))
+	rejectAll(t, box)
+	time.Sleep(20 * time.Millisecond)
+
+	box.Push(pack("b"))
+	if got := rejectedFiles(t, dir); got != 0 {
+		t.Errorf("got %d rejected files, want 0", got)
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+// maxRangePoints ограничивает количество интервалов в одном запросе
+const maxRangePoints = 11000
+
+// Sample — значение метрики, приведённое к float64 для агрегации
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+func (s *MetricService) GetMetricRange(mType string, key string, from time.Time, to time.Time, step time.Duration) ([]model.RangePoint, error) {
+	if !from.Before(to) {
+		return nil, ErrorInvalidRange
+	}
+	if step <= 0 {
+		return nil, ErrorInvalidStep
+	}
+
+	var samples []Sample
+
+	switch mType {
+	case "gauge":
+		history, err := s.GetGaugeHistory(key, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, el := range history {
+			samples = append(samples, Sample{Timestamp: el.Timestamp, Value: float64(el.Value)})
+		}
+
+	case "counter":
+		history, err := s.GetCounterHistory(key, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		// прирост в первом интервале считается от последнего значения до начала периода
+		seed, err := s.store.GetLastCounterSample(key, from)
+		if err != nil {
+			return nil, err
+		}
+		if seed != nil {
+			samples = append(samples, Sample{Timestamp: seed.Timestamp, Value: float64(seed.Value)})
+		}
+
+		for _, el := range history {
+			samples = append(samples, Sample{Timestamp: el.Timestamp, Value: float64(el.Value)})
+		}
+
+	default:
+		return nil, ErrorUnknownMetType
+	}
+
+	return AggregateSamples(samples, from, to, step, mType == "counter")
+}
+
+/*
+AggregateSamples раскладывает отсортированные по времени значения по интервалам [from+i*step, from+(i+1)*step)
+и считает для каждого непустого интервала avg, min, max, sum, last и count.
+Для counter-метрик значения накопленные, поэтому дополнительно считаются increase и rate,
+падение значения (например после рестарта сервера без восстановления) считается сбросом счётчика.
+Значения раньше from не попадают в интервалы, последнее из них служит началом отсчёта increase.
+*/
+func AggregateSamples(samples []Sample, from time.Time, to time.Time, step time.Duration, isCounter bool) ([]model.RangePoint, error) {
+	if !from.Before(to) {
+		return nil, ErrorInvalidRange
+	}
+	if step <= 0 {
+		return nil, ErrorInvalidStep
+	}
+	if int64(to.Sub(from)/step) >= maxRangePoints {
+		return nil, ErrorTooManyPoints
+	}
+
+	var result []model.RangePoint
+	var point *model.RangePoint
+	var bucketIndex int64 = -1
+	var prev float64
+	var hasPrev bool
+
+	for _, sample := range samples {
+		if sample.Timestamp.Before(from) {
+			prev = sample.Value
+			hasPrev = true
+			continue
+		}
+		if sample.Timestamp.After(to) {
+			continue
+		}
+
+		index := int64(sample.Timestamp.Sub(from) / step)
+		if index != bucketIndex || point == nil {
+			result = append(result, model.RangePoint{
+				Timestamp: from.Add(time.Duration(index) * step),
+				Min:       math.Inf(1),
+				Max:       math.Inf(-1),
+			})
+			point = &result[len(result)-1]
+			bucketIndex = index
+
+			if isCounter {
+				point.Increase = new(float64)
+				point.Rate = new(float64)
+			}
+		}
+
+		point.Count++
+		point.Sum += sample.Value
+		point.Last = sample.Value
+		point.Min = math.Min(point.Min, sample.Value)
+		point.Max = math.Max(point.Max, sample.Value)
+		point.Avg = point.Sum / float64(point.Count)
+
+		if isCounter {
+			if hasPrev {
+				if sample.Value >= prev {
+					*point.Increase += sample.Value - prev
+				} else {
+					*point.Increase += sample.Value
+				}
+			}
+			*point.Rate = *point.Increase / step.Seconds()
+			prev = sample.Value
+			hasPrev = true
+		}
+	}
+
+	return result, nil
+}
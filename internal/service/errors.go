@@ -0,0 +1,13 @@
+package service
+
+import "errors"
+
+var (
+	ErrorInvalidRange   = errors.New("invalid time range")
+	ErrorInvalidStep    = errors.New("step must be positive")
+	ErrorTooManyPoints  = errors.New("too many points for requested range and step")
+	ErrorUnknownMetType = errors.New("unknown metric type")
+
+	// ErrorInvalidMetric - в пачке есть метрика неизвестного типа или без значения, /updates/ отвечает 400
+	ErrorInvalidMetric = errors.New("invalid metric")
+)
@@ -2,7 +2,11 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/bbquite/mca-server/internal/model"
 	"github.com/bbquite/mca-server/internal/utils"
@@ -22,15 +26,34 @@ type MemStorageRepo interface {
 	GetGaugeItems() (map[string]model.Gauge, error)
 	GetCounterItems() (map[string]model.Counter, error)
 
+	GetGaugeHistory(key string, from time.Time, to time.Time) ([]model.GaugeSample, error)
+	GetCounterHistory(key string, from time.Time, to time.Time) ([]model.CounterSample, error)
+	GetLastCounterSample(key string, before time.Time) (*model.CounterSample, error)
+
 	Ping() error
 }
 
+// historyStore - хранилище, история которого сохраняется в файл вместе с текущими значениями
+type historyStore interface {
+	ExportHistory() model.MetricHistory
+	ImportHistory(history model.MetricHistory)
+}
+
+// historyPath возвращает путь к файлу истории рядом с файлом значений
+func historyPath(filePath string) string {
+	return filePath + ".history"
+}
+
 type MetricService struct {
 	store           MemStorageRepo
 	syncSave        bool
 	filePath        string
 	isDatabaseUsage bool
 	logger          *zap.SugaredLogger
+
+	snapshotMx sync.Mutex
+	inFlight   inFlight
+	committed  map[string]model.Counter // дельты, подтверждённые сервером и вычтенные из хранилища
 }
 
 func NewMetricService(store MemStorageRepo, syncSave bool, isDatabaseUsage bool, filePath string) (*MetricService, error) {
@@ -46,6 +69,8 @@ func NewMetricService(store MemStorageRepo, syncSave bool, isDatabaseUsage bool,
 		filePath:        filePath,
 		isDatabaseUsage: isDatabaseUsage,
 		logger:          logger,
+		inFlight:        make(inFlight),
+		committed:       make(map[string]model.Counter),
 	}, nil
 }
 
@@ -132,6 +157,24 @@ func (s *MetricService) GetCounterItems() (map[string]model.Counter, error) {
 	return items, nil
 }
 
+func (s *MetricService) GetGaugeHistory(key string, from time.Time, to time.Time) ([]model.GaugeSample, error) {
+	items, err := s.store.GetGaugeHistory(key, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (s *MetricService) GetCounterHistory(key string, from time.Time, to time.Time) ([]model.CounterSample, error) {
+	items, err := s.store.GetCounterHistory(key, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
 func (s *MetricService) GetAllMetrics() (model.MetricsPack, error) {
 	var metricResult model.MetricsPack
 
@@ -187,6 +230,10 @@ func (s *MetricService) ExportToJSON() ([]byte, error) {
 	return metricsJSON, nil
 }
 
+/*
+ImportFromJSON сохраняет пачку метрик в JSON (формат /updates/ и файла хранилища).
+Пачка проверяется целиком до записи: при ошибке ErrorInvalidMetric не сохраняется ни одна метрика
+*/
 func (s *MetricService) ImportFromJSON(data []byte) error {
 	var metricStruct model.MetricsPack
 
@@ -195,24 +242,39 @@ func (s *MetricService) ImportFromJSON(data []byte) error {
 		return err
 	}
 
-	if s.isDatabaseUsage {
-		s.store.AddMetricsPack(&metricStruct)
-		return nil
-	}
+	return s.AddMetricsPack(metricStruct)
+}
 
-	for _, element := range metricStruct {
+// AddMetricsPack сохраняет пачку метрик одной операцией хранилища
+func (s *MetricService) AddMetricsPack(metrics model.MetricsPack) error {
+	for _, element := range metrics {
 		switch element.MType {
 		case "gauge":
-			_, err = s.AddGaugeItem(element.ID, model.Gauge(*element.Value))
-			if err != nil {
-				return err
+			if element.Value == nil {
+				return fmt.Errorf("%w: gauge %s without value", ErrorInvalidMetric, element.ID)
 			}
-
 		case "counter":
-			_, err = s.AddCounterItem(element.ID, model.Counter(*element.Delta))
-			if err != nil {
-				return err
+			if element.Delta == nil {
+				return fmt.Errorf("%w: counter %s without delta", ErrorInvalidMetric, element.ID)
 			}
+		default:
+			return fmt.Errorf("%w: %s has unknown type %q", ErrorInvalidMetric, element.ID, element.MType)
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	err := s.store.AddMetricsPack(&metrics)
+	if err != nil {
+		return err
+	}
+
+	if s.syncSave {
+		err = s.SaveToFile(s.filePath)
+		if err != nil {
+			s.logger.Error(err)
 		}
 	}
 	return nil
@@ -226,6 +288,16 @@ func (s *MetricService) SaveToFile(filePath string) error {
 	}
 	os.WriteFile(filePath, data, 0666)
 
+	if store, ok := s.store.(historyStore); ok {
+		history, err := json.Marshal(store.ExportHistory())
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(historyPath(filePath), history, 0666); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -238,5 +310,22 @@ func (s *MetricService) LoadFromFile(filePath string) error {
 	if err := s.ImportFromJSON(data); err != nil {
 		return err
 	}
+
+	// файла истории может не быть, если он сохранён до появления истории
+	if store, ok := s.store.(historyStore); ok {
+		data, err := os.ReadFile(historyPath(filePath))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var history model.MetricHistory
+		if err := json.Unmarshal(data, &history); err != nil {
+			return fmt.Errorf("%s: %w", historyPath(filePath), err)
+		}
+		store.ImportHistory(history)
+	}
 	return nil
 }
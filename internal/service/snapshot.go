@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/bbquite/mca-server/internal/model"
+)
+
+var ErrorSnapshotDone = errors.New("snapshot is already committed or released")
+
+/*
+MetricsSnapshot - метрики, подготовленные к отправке. Counter-метрики в нём содержат
+дельту, накопленную с последней подтверждённой отправки, за вычетом дельт других
+неподтверждённых снимков.
+*/
+type MetricsSnapshot struct {
+	Metrics  model.MetricsPack
+	counters map[string]model.Counter
+	done     bool
+}
+
+// inFlight учитывает дельты counter-метрик, отправленные, но ещё не подтверждённые сервером
+type inFlight map[string]model.Counter
+
+/*
+SnapshotMetrics собирает текущие метрики для отправки, не изменяя хранилище.
+После ответа сервера снимок нужно передать в CommitSnapshot (дельты вычитаются из хранилища)
+или ReleaseSnapshot (дельты возвращаются в следующий снимок).
+*/
+func (s *MetricService) SnapshotMetrics() (*MetricsSnapshot, error) {
+	s.snapshotMx.Lock()
+	defer s.snapshotMx.Unlock()
+
+	metrics, err := s.GetAllMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &MetricsSnapshot{
+		Metrics:  make(model.MetricsPack, 0, len(metrics)),
+		counters: make(map[string]model.Counter),
+	}
+
+	for _, metric := range metrics {
+		if metric.MType == "counter" {
+			delta := *metric.Delta - int64(s.inFlight[metric.ID])
+			metric.Delta = &delta
+
+			snapshot.counters[metric.ID] = model.Counter(delta)
+			s.inFlight[metric.ID] += model.Counter(delta)
+		}
+		snapshot.Metrics = append(snapshot.Metrics, metric)
+	}
+
+	return snapshot, nil
+}
+
+// CommitSnapshot вычитает отправленные дельты counter-метрик после подтверждения сервера
+func (s *MetricService) CommitSnapshot(snapshot *MetricsSnapshot) error {
+	s.snapshotMx.Lock()
+	defer s.snapshotMx.Unlock()
+
+	if snapshot.done {
+		return ErrorSnapshotDone
+	}
+	snapshot.done = true
+
+	var errs []error
+	for key, delta := range snapshot.counters {
+		s.releaseInFlight(key, delta)
+		if delta == 0 {
+			continue
+		}
+
+		err := s.store.AddCounterItem(key, -delta)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.committed[key] += delta
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+GetCumulativeMetrics возвращает метрики, в которых counter содержит накопленное значение
+с момента запуска, включая уже подтверждённые сервером дельты. В отличие от SnapshotMetrics
+чтение ничего не изменяет, поэтому его могут выполнять несколько потребителей, каждый из которых
+сам вычисляет дельты между своими чтениями
+*/
+func (s *MetricService) GetCumulativeMetrics() (model.MetricsPack, error) {
+	s.snapshotMx.Lock()
+	defer s.snapshotMx.Unlock()
+
+	metrics, err := s.GetAllMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, metric := range metrics {
+		if metric.MType == "counter" {
+			total := *metric.Delta + int64(s.committed[metric.ID])
+			metrics[i].Delta = &total
+		}
+	}
+	return metrics, nil
+}
+
+// ReleaseSnapshot возвращает неотправленные дельты, и они попадут в следующий снимок
+func (s *MetricService) ReleaseSnapshot(snapshot *MetricsSnapshot) {
+	s.snapshotMx.Lock()
+	defer s.snapshotMx.Unlock()
+
+	if snapshot.done {
+		return
+	}
+	snapshot.done = true
+
+	for key, delta := range snapshot.counters {
+		s.releaseInFlight(key, delta)
+	}
+}
+
+func (s *MetricService) releaseInFlight(key string, delta model.Counter) {
+	s.inFlight[key] -= delta
+	if s.inFlight[key] == 0 {
+		delete(s.inFlight, key)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/service"
+)
+
+func Test_AggregateSamples(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Minute)
+	at := func(offset time.Duration) time.Time { return from.Add(offset) }
+
+	type want struct {
+		offset   time.Duration
+		count    int
+		avg      float64
+		min      float64
+		max      float64
+		sum      float64
+		last     float64
+		increase float64
+	}
+	tests := []struct {
+		name      string
+		samples   []service.Sample
+		isCounter bool
+		step      time.Duration
+		want      []want
+	}{
+		{
+			name: "gauge buckets",
+			samples: []service.Sample{
+				{Timestamp: at(1 * time.Second), Value: 10},
+				{Timestamp: at(5 * time.Second), Value: 20},
+				{Timestamp: at(35 * time.Second), Value: 25},
+				{Timestamp: at(40 * time.Second), Value: 5},
+			},
+			step: 30 * time.Second,
+			want: []want{
+				{offset: 0, count: 2, avg: 15, min: 10, max: 20, sum: 30, last: 20},
+				{offset: 30 * time.Second, count: 2, avg: 15, min: 5, max: 25, sum: 30, last: 5},
+			},
+		},
+		{
+			name: "empty buckets are skipped",
+			samples: []service.Sample{
+				{Timestamp: at(1 * time.Second), Value: 1},
+				{Timestamp: at(52 * time.Second), Value: 3},
+			},
+			step: 10 * time.Second,
+			want: []want{
+				{offset: 0, count: 1, avg: 1, min: 1, max: 1, sum: 1, last: 1},
+				{offset: 50 * time.Second, count: 1, avg: 3, min: 3, max: 3, sum: 3, last: 3},
+			},
+		},
+		{
+			name: "samples outside the range",
+			samples: []service.Sample{
+				{Timestamp: at(-time.Second), Value: 100},
+				{Timestamp: at(10 * time.Second), Value: 2},
+				{Timestamp: at(2 * time.Minute), Value: 100},
+			},
+			step: time.Minute,
+			want: []want{
+				{offset: 0, count: 1, avg: 2, min: 2, max: 2, sum: 2, last: 2},
+			},
+		},
+		{
+			name: "counter buckets with reset",
+			samples: []service.Sample{
+				{Timestamp: at(1 * time.Second), Value: 10},
+				{Timestamp: at(5 * time.Second), Value: 20},
+				{Timestamp: at(35 * time.Second), Value: 25},
+				{Timestamp: at(40 * time.Second), Value: 5}, // сброс счётчика
+			},
+			isCounter: true,
+			step:      30 * time.Second,
+			want: []want{
+				{offset: 0, count: 2, avg: 15, min: 10, max: 20, sum: 30, last: 20, increase: 10},
+				{offset: 30 * time.Second, count: 2, avg: 15, min: 5, max: 25, sum: 30, last: 5, increase: 10},
+			},
+		},
+		{
+			name: "counter seeded by the last sample before from",
+			samples: []service.Sample{
+				{Timestamp: at(-20 * time.Second), Value: 1},
+				{Timestamp: at(-10 * time.Second), Value: 4},
+				{Timestamp: at(1 * time.Second), Value: 10},
+				{Timestamp: at(35 * time.Second), Value: 12},
+			},
+			isCounter: true,
+			step:      30 * time.Second,
+			want: []want{
+				{offset: 0, count: 1, avg: 10, min: 10, max: 10, sum: 10, last: 10, increase: 6},
+				{offset: 30 * time.Second, count: 1, avg: 12, min: 12, max: 12, sum: 12, last: 12, increase: 2},
+			},
+		},
+		{
+			name: "counter without samples before from",
+			samples: []service.Sample{
+				{Timestamp: at(1 * time.Second), Value: 10},
+			},
+			isCounter: true,
+			step:      time.Minute,
+			want: []want{
+				{offset: 0, count: 1, avg: 10, min: 10, max: 10, sum: 10, last: 10, increase: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points, err := service.AggregateSamples(test.samples, from, to, test.step, test.isCounter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != len(test.want) {
+				t.Fatalf("got %d points, want %d", len(points), len(test.want))
+			}
+
+			for i, w := range test.want {
+				p := points[i]
+				if !p.Timestamp.Equal(at(w.offset)) {
+					t.Errorf("point %d timestamp = %v, want %v", i, p.Timestamp, at(w.offset))
+				}
+				if p.Count != w.count || p.Avg != w.avg || p.Min != w.min || p.Max != w.max || p.Sum != w.sum || p.Last != w.last {
+					t.Errorf("point %d = %+v, want %+v", i, p, w)
+				}
+				if test.isCounter {
+					if p.Increase == nil || *p.Increase != w.increase {
+						t.Errorf("point %d increase = %v, want %v", i, p.Increase, w.increase)
+					}
+					if p.Rate == nil || *p.Rate != w.increase/test.step.Seconds() {
+						t.Errorf("point %d rate = %v, want %v", i, p.Rate, w.increase/test.step.Seconds())
+					}
+				} else if p.Increase != nil || p.Rate != nil {
+					t.Errorf("point %d: gauge must not have rate/increase", i)
+				}
+			}
+		})
+	}
+}
+
+func Test_AggregateSamplesErrors(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		step time.Duration
+		want error
+	}{
+		{name: "inverted range", from: from.Add(time.Minute), to: from, step: time.Second, want: service.ErrorInvalidRange},
+		{name: "empty range", from: from, to: from, step: time.Second, want: service.ErrorInvalidRange},
+		{name: "zero step", from: from, to: from.Add(time.Minute), step: 0, want: service.ErrorInvalidStep},
+		{name: "negative step", from: from, to: from.Add(time.Minute), step: -time.Second, want: service.ErrorInvalidStep},
+		{name: "too many points", from: from, to: from.Add(24 * time.Hour), step: time.Second, want: service.ErrorTooManyPoints},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := service.AggregateSamples(nil, test.from, test.to, test.step, false)
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+)
+
+func Test_FileStorageHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	saved, err := service.NewMetricService(storage.NewMemStorage(storage.DefaultHistoryLimit), false, false, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []int64{1, 2, 3} {
+		saved.AddCounterItem("PollCount", model.Counter(value))
+	}
+	if err := saved.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := service.NewMetricService(storage.NewMemStorage(storage.DefaultHistoryLimit), false, false, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	history, err := restored.GetCounterHistory("PollCount", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{1, 3, 6}
+	if len(history) != len(want) {
+		t.Fatalf("got %v, want values %v", history, want)
+	}
+	for i, sample := range history {
+		if int64(sample.Value) != want[i] {
+			t.Errorf("sample %d: got %v, want %v", i, sample.Value, want[i])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/service"
+	"github.com/bbquite/mca-server/internal/storage"
+)
+
+func snapshotDelta(t *testing.T, snapshot *service.MetricsSnapshot, key string) int64 {
+	t.Helper()
+	for _, metric := range snapshot.Metrics {
+		if metric.ID == key {
+			return *metric.Delta
+		}
+	}
+	t.Fatalf("counter %s not found in snapshot", key)
+	return 0
+}
+
+func Test_SnapshotCommitAndRelease(t *testing.T) {
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services.AddCounterItem("PollCount", 5)
+
+	// неудачная отправка: дельта возвращается в следующий снимок
+	failed, err := services.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.AddCounterItem("PollCount", 2)
+	services.ReleaseSnapshot(failed)
+
+	first, err := services.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshotDelta(t, first, "PollCount"); got != 7 {
+		t.Errorf("first snapshot delta = %d, want 7", got)
+	}
+
+	// пока первый снимок не подтверждён, второй содержит только новые значения
+	services.AddCounterItem("PollCount", 3)
+	second, err := services.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshotDelta(t, second, "PollCount"); got != 3 {
+		t.Errorf("second snapshot delta = %d, want 3", got)
+	}
+
+	if err := services.CommitSnapshot(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.CommitSnapshot(first); err == nil {
+		t.Errorf("repeated commit must fail")
+	}
+	services.ReleaseSnapshot(second)
+
+	value, _ := services.GetCounterItem("PollCount")
+	if value != 3 {
+		t.Errorf("counter after commit = %d, want 3", value)
+	}
+}
+
+func Test_CumulativeMetrics(t *testing.T) {
+	services, err := service.NewMetricService(storage.NewMemStorage(0), false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services.AddCounterItem("PollCount", 5)
+	snapshot, err := services.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.CommitSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	services.AddCounterItem("PollCount", 2)
+
+	// накопленное значение учитывает подтверждённые дельты, а чтение его не изменяет
+	for i := 0; i < 2; i++ {
+		metrics, err := services.GetCumulativeMetrics()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metrics) != 1 || *metrics[0].Delta != 7 {
+			t.Errorf("read %d: got %+v, want PollCount=7", i, metrics)
+		}
+	}
+
+	next, err := services.SnapshotMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshotDelta(t, next, "PollCount"); got != 2 {
+		t.Errorf("snapshot delta after cumulative reads = %d, want 2", got)
+	}
+}
@@ -58,6 +58,19 @@ func (storage *DBStorage) CheckDatabaseValid() error {
 		);
 	`
 
+	sqlCreateSamplesString := `
+		CREATE TABLE IF NOT EXISTS metric_samples (
+			id bigserial PRIMARY KEY,
+			metric_type metric_type not null,
+			metric_name varchar(55) not null,
+			delta bigint,
+			value double precision,
+			created_at timestamptz not null default now()
+		);
+		CREATE INDEX IF NOT EXISTS metric_samples_name_time_idx
+			ON metric_samples (metric_name, created_at);
+	`
+
 	_, err = storage.Conn.ExecContext(storage.ctx, sqlCheckString)
 
 	if err != nil {
@@ -70,6 +83,13 @@ func (storage *DBStorage) CheckDatabaseValid() error {
 				}
 			}
 		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = storage.Conn.ExecContext(storage.ctx, sqlCreateSamplesString)
+	if err != nil {
 		return err
 	}
 
@@ -88,24 +108,61 @@ func (storage *DBStorage) Ping() error {
 	return nil
 }
 
-func (storage *DBStorage) AddMetricItem(mType string, key string, value any) error {
-
-	sqlString := `
+/*
+sqlUpsertGauge сохраняет gauge-метрику в историю. Текущее значение обновляется,
+только если в истории нет более поздней точки, иначе запоздавшее значение его бы затёрло
+*/
+const sqlUpsertGauge = `
+	WITH upd AS (
 		INSERT INTO metrics (metric_type, metric_name, value)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (metric_name) DO UPDATE SET value = $3
-	`
+		WHERE NOT EXISTS (
+			SELECT 1 FROM metric_samples
+			WHERE metric_type = 'GAUGE' AND metric_name = $2 AND created_at > COALESCE($4::timestamptz, now())
+		)
+	)
+	INSERT INTO metric_samples (metric_type, metric_name, value, created_at)
+	VALUES ($1, $2, $3, COALESCE($4::timestamptz, now()))
+`
+
+/*
+sqlUpsertCounter увеличивает counter-метрику и сохраняет в историю накопленное значение.
+Запоздавшая дельта добавляется к предыдущей точке истории и ко всем более поздним,
+чтобы история оставалась монотонной. Все части запроса видят данные до его выполнения
+*/
+const sqlUpsertCounter = `
+	WITH upd AS (
+		INSERT INTO metrics (metric_type, metric_name, delta)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (metric_name) DO UPDATE SET delta = metrics.delta + $3
+		RETURNING delta
+	), later AS (
+		UPDATE metric_samples SET delta = delta + $3
+		WHERE metric_type = 'COUNTER' AND metric_name = $2 AND created_at > COALESCE($4::timestamptz, now())
+		RETURNING id
+	)
+	INSERT INTO metric_samples (metric_type, metric_name, delta, created_at)
+	SELECT $1, $2,
+		CASE WHEN EXISTS (SELECT 1 FROM later) THEN COALESCE((
+			SELECT delta FROM metric_samples
+			WHERE metric_type = 'COUNTER' AND metric_name = $2 AND created_at <= COALESCE($4::timestamptz, now())
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		), 0) + $3 ELSE upd.delta END,
+		COALESCE($4::timestamptz, now())
+	FROM upd
+`
+
+func (storage *DBStorage) AddMetricItem(mType string, key string, value any) error {
 
+	sqlString := sqlUpsertGauge
 	if mType == "COUNTER" {
-		sqlString = `
-			INSERT INTO metrics (metric_type, metric_name, delta)
-			VALUES ($1, $2, $3)
-			ON CONFLICT (metric_name) DO UPDATE SET delta = metrics.delta + $3
-		`
+		sqlString = sqlUpsertCounter
 	}
 
 	retryFunction := func() error {
-		_, err := storage.Conn.ExecContext(storage.ctx, sqlString, mType, key, value)
+		_, err := storage.Conn.ExecContext(storage.ctx, sqlString, mType, key, value, nil)
 		return err
 	}
 
@@ -249,6 +306,125 @@ func (storage *DBStorage) GetCounterItems() (map[string]model.Counter, error) {
 	return result, nil
 }
 
+func (storage *DBStorage) GetGaugeHistory(key string, from time.Time, to time.Time) ([]model.GaugeSample, error) {
+
+	var result []model.GaugeSample
+
+	sqlStringSelect := `
+		SELECT created_at, value
+		FROM metric_samples
+		WHERE metric_type = 'GAUGE' AND metric_name = $1 AND created_at BETWEEN $2 AND $3
+		ORDER BY created_at
+	`
+
+	retryFunction := func() error {
+		result = result[:0]
+
+		rows, err := storage.Conn.QueryContext(storage.ctx, sqlStringSelect, key, from, to)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sample model.GaugeSample
+			if err := rows.Scan(&sample.Timestamp, &sample.Value); err != nil {
+				return err
+			}
+			result = append(result, sample)
+		}
+
+		return rows.Err()
+	}
+
+	err := storage.retrier.Retry(retryFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		if _, err := storage.GetGaugeItem(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (storage *DBStorage) GetCounterHistory(key string, from time.Time, to time.Time) ([]model.CounterSample, error) {
+
+	var result []model.CounterSample
+
+	sqlStringSelect := `
+		SELECT created_at, delta
+		FROM metric_samples
+		WHERE metric_type = 'COUNTER' AND metric_name = $1 AND created_at BETWEEN $2 AND $3
+		ORDER BY created_at
+	`
+
+	retryFunction := func() error {
+		result = result[:0]
+
+		rows, err := storage.Conn.QueryContext(storage.ctx, sqlStringSelect, key, from, to)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sample model.CounterSample
+			if err := rows.Scan(&sample.Timestamp, &sample.Value); err != nil {
+				return err
+			}
+			result = append(result, sample)
+		}
+
+		return rows.Err()
+	}
+
+	err := storage.retrier.Retry(retryFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		if _, err := storage.GetCounterItem(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetLastCounterSample возвращает последнюю точку истории counter-метрики раньше before либо nil, если её нет
+func (storage *DBStorage) GetLastCounterSample(key string, before time.Time) (*model.CounterSample, error) {
+
+	var sample model.CounterSample
+
+	sqlStringSelect := `
+		SELECT created_at, delta
+		FROM metric_samples
+		WHERE metric_type = 'COUNTER' AND metric_name = $1 AND created_at < $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	retryFunction := func() error {
+		row := storage.Conn.QueryRowContext(storage.ctx, sqlStringSelect, key, before)
+		return row.Scan(&sample.Timestamp, &sample.Value)
+	}
+
+	err := storage.retrier.Retry(retryFunction)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &sample, nil
+}
+
 func (storage *DBStorage) AddMetricsPack(metrics *model.MetricsPack) error {
 
 	tx, err := storage.Conn.Begin()
@@ -263,22 +439,20 @@ func (storage *DBStorage) AddMetricsPack(metrics *model.MetricsPack) error {
 		mType := strings.ToUpper(el.MType)
 		switch mType {
 		case "GAUGE":
-			sqlString = `
-				INSERT INTO metrics (metric_type, metric_name, value)
-				VALUES ($1, $2, $3)
-				ON CONFLICT (metric_name) DO UPDATE SET value = $3
-			`
+			sqlString = sqlUpsertGauge
 			value = el.Value
 		case "COUNTER":
-			sqlString = `
-				INSERT INTO metrics (metric_type, metric_name, delta)
-				VALUES ($1, $2, $3)
-				ON CONFLICT (metric_name) DO UPDATE SET delta = metrics.delta + $3
-			`
+			sqlString = sqlUpsertCounter
 			value = el.Delta
 		}
 
-		_, err := tx.ExecContext(storage.ctx, sqlString, mType, el.ID, value)
+		var ts *time.Time
+		if el.TS != nil {
+			t := time.UnixMilli(*el.TS)
+			ts = &t
+		}
+
+		_, err := tx.ExecContext(storage.ctx, sqlString, mType, el.ID, value, ts)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -1,40 +1,136 @@
 package storage
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/bbquite/mca-server/internal/model"
 )
 
+// DefaultHistoryLimit - количество хранимых в памяти значений одной метрики по умолчанию
+const DefaultHistoryLimit = 10000
+
 type MemStorage struct {
-	GaugeItems   map[string]model.Gauge
-	CounterItems map[string]model.Counter
-	mx           sync.RWMutex
+	GaugeItems     map[string]model.Gauge
+	CounterItems   map[string]model.Counter
+	GaugeHistory   map[string][]model.GaugeSample
+	CounterHistory map[string][]model.CounterSample
+	gaugeTimes     map[string]time.Time // время текущего значения gauge, более старые значения его не заменяют
+	historyLimit   int
+
+	// метрики, у которых начало истории отброшено: значения старше первой точки в историю не попадают
+	gaugeTruncated   map[string]bool
+	counterTruncated map[string]bool
+
+	mx sync.RWMutex
 }
 
-func NewMemStorage() *MemStorage {
+/*
+NewMemStorage создаёт инмемори хранилище. История значений хранит не больше historyLimit
+точек на метрику, при превышении самые старые отбрасываются. При historyLimit равном 0
+история не ведётся, агенту она не нужна
+*/
+func NewMemStorage(historyLimit int) *MemStorage {
 	return &MemStorage{
-		GaugeItems:   make(map[string]model.Gauge),
-		CounterItems: make(map[string]model.Counter),
+		GaugeItems:       make(map[string]model.Gauge),
+		CounterItems:     make(map[string]model.Counter),
+		GaugeHistory:     make(map[string][]model.GaugeSample),
+		CounterHistory:   make(map[string][]model.CounterSample),
+		gaugeTimes:       make(map[string]time.Time),
+		historyLimit:     historyLimit,
+		gaugeTruncated:   make(map[string]bool),
+		counterTruncated: make(map[string]bool),
+	}
+}
+
+// sampleTime возвращает время измерения метрики либо now, если оно не передано
+func sampleTime(metric model.Metric, now time.Time) time.Time {
+	if metric.TS == nil {
+		return now
+	}
+	return time.UnixMilli(*metric.TS)
+}
+
+func (storage *MemStorage) addGaugeSample(key string, value model.Gauge, ts time.Time) {
+	if last, ok := storage.gaugeTimes[key]; !ok || !ts.Before(last) {
+		storage.GaugeItems[key] = value
+		storage.gaugeTimes[key] = ts
+	}
+	if storage.historyLimit <= 0 {
+		return
+	}
+
+	history := storage.GaugeHistory[key]
+	sample := model.GaugeSample{Timestamp: ts, Value: value}
+	if n := len(history); n > 0 && ts.Before(history[n-1].Timestamp) {
+		// значения с явным временем могут прийти не по порядку
+		i := sort.Search(n, func(i int) bool { return history[i].Timestamp.After(ts) })
+		if i == 0 && storage.gaugeTruncated[key] {
+			// значение старше отброшенного начала истории
+			return
+		}
+		history = append(history[:i], append([]model.GaugeSample{sample}, history[i:]...)...)
+	} else {
+		history = append(history, sample)
+	}
+
+	if len(history) > storage.historyLimit {
+		history = history[len(history)-storage.historyLimit:]
+		storage.gaugeTruncated[key] = true
+	}
+	storage.GaugeHistory[key] = history
+}
+
+func (storage *MemStorage) addCounterSample(key string, value model.Counter, ts time.Time) {
+	storage.CounterItems[key] += value
+	if storage.historyLimit <= 0 {
+		return
+	}
+
+	history := storage.CounterHistory[key]
+	if n := len(history); n > 0 && ts.Before(history[n-1].Timestamp) {
+		// в историю пишется накопленное значение: запоздавшая дельта добавляется к предыдущей точке
+		// и ко всем более поздним, чтобы история оставалась монотонной
+		i := sort.Search(n, func(i int) bool { return history[i].Timestamp.After(ts) })
+		for j := i; j < n; j++ {
+			history[j].Value += value
+		}
+
+		// накопленное значение перед отброшенным началом истории неизвестно,
+		// поэтому такая дельта учитывается только в более поздних точках
+		if i == 0 && storage.counterTruncated[key] {
+			return
+		}
+
+		var prev model.Counter
+		if i > 0 {
+			prev = history[i-1].Value
+		}
+		sample := model.CounterSample{Timestamp: ts, Value: prev + value}
+		history = append(history[:i], append([]model.CounterSample{sample}, history[i:]...)...)
+	} else {
+		history = append(history, model.CounterSample{Timestamp: ts, Value: storage.CounterItems[key]})
 	}
+
+	if len(history) > storage.historyLimit {
+		history = history[len(history)-storage.historyLimit:]
+		storage.counterTruncated[key] = true
+	}
+	storage.CounterHistory[key] = history
 }
 
 func (storage *MemStorage) AddGaugeItem(key string, value model.Gauge) error {
 	storage.mx.Lock()
 	defer storage.mx.Unlock()
-	storage.GaugeItems[key] = value
+	storage.addGaugeSample(key, value, time.Now())
 	return nil
 }
 
 func (storage *MemStorage) AddCounterItem(key string, value model.Counter) error {
 	storage.mx.Lock()
 	defer storage.mx.Unlock()
-
-	if _, ok := storage.CounterItems[key]; ok {
-		storage.CounterItems[key] = storage.CounterItems[key] + value
-	} else {
-		storage.CounterItems[key] = value
-	}
+	storage.addCounterSample(key, value, time.Now())
 	return nil
 }
 
@@ -58,24 +154,82 @@ func (storage *MemStorage) GetCounterItem(key string) (model.Counter, error) {
 	return 0, ErrorCounterNotFound
 }
 
+// GetGaugeItems возвращает копию, чтобы её можно было обходить параллельно с записью новых значений
 func (storage *MemStorage) GetGaugeItems() (map[string]model.Gauge, error) {
 	storage.mx.RLock()
 	defer storage.mx.RUnlock()
-	result := storage.GaugeItems
+	result := make(map[string]model.Gauge, len(storage.GaugeItems))
+	for key, value := range storage.GaugeItems {
+		result[key] = value
+	}
 	return result, nil
 }
 
 func (storage *MemStorage) GetCounterItems() (map[string]model.Counter, error) {
 	storage.mx.RLock()
 	defer storage.mx.RUnlock()
-	result := storage.CounterItems
+	result := make(map[string]model.Counter, len(storage.CounterItems))
+	for key, value := range storage.CounterItems {
+		result[key] = value
+	}
 	return result, nil
 }
 
-func (storage *MemStorage) ResetCounterItem(key string) error {
+func (storage *MemStorage) GetGaugeHistory(key string, from time.Time, to time.Time) ([]model.GaugeSample, error) {
+	storage.mx.RLock()
+	defer storage.mx.RUnlock()
+
+	// существующая метрика без записанной истории отдаёт пустой диапазон, как DBStorage
+	if _, ok := storage.GaugeItems[key]; !ok {
+		return nil, ErrorGaugeNotFound
+	}
+	history := storage.GaugeHistory[key]
+
+	start := sort.Search(len(history), func(i int) bool { return !history[i].Timestamp.Before(from) })
+	end := sort.Search(len(history), func(i int) bool { return history[i].Timestamp.After(to) })
+
+	result := make([]model.GaugeSample, end-start)
+	copy(result, history[start:end])
+	return result, nil
+}
+
+func (storage *MemStorage) GetCounterHistory(key string, from time.Time, to time.Time) ([]model.CounterSample, error) {
 	storage.mx.RLock()
 	defer storage.mx.RUnlock()
 
+	// существующая метрика без записанной истории отдаёт пустой диапазон, как DBStorage
+	if _, ok := storage.CounterItems[key]; !ok {
+		return nil, ErrorCounterNotFound
+	}
+	history := storage.CounterHistory[key]
+
+	start := sort.Search(len(history), func(i int) bool { return !history[i].Timestamp.Before(from) })
+	end := sort.Search(len(history), func(i int) bool { return history[i].Timestamp.After(to) })
+
+	result := make([]model.CounterSample, end-start)
+	copy(result, history[start:end])
+	return result, nil
+}
+
+// GetLastCounterSample возвращает последнюю точку истории counter-метрики раньше before либо nil, если её нет
+func (storage *MemStorage) GetLastCounterSample(key string, before time.Time) (*model.CounterSample, error) {
+	storage.mx.RLock()
+	defer storage.mx.RUnlock()
+
+	history := storage.CounterHistory[key]
+	i := sort.Search(len(history), func(i int) bool { return !history[i].Timestamp.Before(before) })
+	if i == 0 {
+		return nil, nil
+	}
+
+	sample := history[i-1]
+	return &sample, nil
+}
+
+func (storage *MemStorage) ResetCounterItem(key string) error {
+	storage.mx.Lock()
+	defer storage.mx.Unlock()
+
 	if _, ok := storage.CounterItems[key]; ok {
 		storage.CounterItems[key] = model.Counter(0)
 		return nil
@@ -87,22 +241,88 @@ func (storage *MemStorage) AddMetricsPack(metrics *model.MetricsPack) error {
 	storage.mx.Lock()
 	defer storage.mx.Unlock()
 
+	now := time.Now()
 	for _, element := range *metrics {
 		switch element.MType {
 		case "gauge":
-			storage.GaugeItems[element.ID] = model.Gauge(*element.Value)
+			storage.addGaugeSample(element.ID, model.Gauge(*element.Value), sampleTime(element, now))
 
 		case "counter":
-			if _, ok := storage.CounterItems[element.ID]; ok {
-				storage.CounterItems[element.ID] = storage.CounterItems[element.ID] + model.Counter(*element.Value)
-			} else {
-				storage.CounterItems[element.ID] = model.Counter(*element.Value)
-			}
+			storage.addCounterSample(element.ID, model.Counter(*element.Delta), sampleTime(element, now))
 		}
 	}
 	return nil
 }
 
+// ExportHistory возвращает копию истории значений для сохранения в файл
+func (storage *MemStorage) ExportHistory() model.MetricHistory {
+	storage.mx.RLock()
+	defer storage.mx.RUnlock()
+
+	result := model.MetricHistory{
+		Gauges:   make(map[string][]model.GaugeSample, len(storage.GaugeHistory)),
+		Counters: make(map[string][]model.CounterSample, len(storage.CounterHistory)),
+	}
+	for key, history := range storage.GaugeHistory {
+		result.Gauges[key] = append([]model.GaugeSample(nil), history...)
+	}
+	for key, history := range storage.CounterHistory {
+		result.Counters[key] = append([]model.CounterSample(nil), history...)
+	}
+	for key := range storage.gaugeTruncated {
+		result.TruncatedGauges = append(result.TruncatedGauges, key)
+	}
+	for key := range storage.counterTruncated {
+		result.TruncatedCounters = append(result.TruncatedCounters, key)
+	}
+	return result
+}
+
+/*
+ImportHistory заменяет историю значений сохранённой. Вызывается после восстановления
+текущих значений, которое само добавляет точки истории. Точки сверх historyLimit отбрасываются
+*/
+func (storage *MemStorage) ImportHistory(history model.MetricHistory) {
+	storage.mx.Lock()
+	defer storage.mx.Unlock()
+
+	if storage.historyLimit <= 0 {
+		return
+	}
+
+	storage.GaugeHistory = make(map[string][]model.GaugeSample, len(history.Gauges))
+	storage.CounterHistory = make(map[string][]model.CounterSample, len(history.Counters))
+	storage.gaugeTruncated = make(map[string]bool)
+	storage.counterTruncated = make(map[string]bool)
+
+	for _, key := range history.TruncatedGauges {
+		storage.gaugeTruncated[key] = true
+	}
+	for _, key := range history.TruncatedCounters {
+		storage.counterTruncated[key] = true
+	}
+
+	for key, samples := range history.Gauges {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+		if len(samples) > storage.historyLimit {
+			samples = samples[len(samples)-storage.historyLimit:]
+			storage.gaugeTruncated[key] = true
+		}
+		if len(samples) > 0 {
+			storage.gaugeTimes[key] = samples[len(samples)-1].Timestamp
+		}
+		storage.GaugeHistory[key] = samples
+	}
+	for key, samples := range history.Counters {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+		if len(samples) > storage.historyLimit {
+			samples = samples[len(samples)-storage.historyLimit:]
+			storage.counterTruncated[key] = true
+		}
+		storage.CounterHistory[key] = samples
+	}
+}
+
 func (storage *MemStorage) Ping() error {
 	// инмемори хранилище всегда доступно поэтому ошибок быть не может
 	return nil
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/model"
+	"github.com/bbquite/mca-server/internal/storage"
+)
+
+func Test_MemStorageHistoryDisabled(t *testing.T) {
+	db := storage.NewMemStorage(0)
+	db.AddGaugeItem("Alloc", 1.5)
+	db.AddCounterItem("PollCount", 3)
+
+	now := time.Now()
+
+	gauges, err := db.GetGaugeHistory("Alloc", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(gauges) != 0 {
+		t.Fatalf("gauge history: got %v, %v; want empty", gauges, err)
+	}
+
+	counters, err := db.GetCounterHistory("PollCount", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(counters) != 0 {
+		t.Fatalf("counter history: got %v, %v; want empty", counters, err)
+	}
+
+	_, err = db.GetGaugeHistory("Unknown", now.Add(-time.Hour), now.Add(time.Hour))
+	if !errors.Is(err, storage.ErrorGaugeNotFound) {
+		t.Fatalf("unknown gauge: got %v, want %v", err, storage.ErrorGaugeNotFound)
+	}
+}
+
+func Test_MemStorageHistoryEnabled(t *testing.T) {
+	db := storage.NewMemStorage(storage.DefaultHistoryLimit)
+	db.AddGaugeItem("Alloc", 1.5)
+	db.AddGaugeItem("Alloc", 2.5)
+
+	now := time.Now()
+	gauges, err := db.GetGaugeHistory("Alloc", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(gauges) != 2 {
+		t.Fatalf("gauge history: got %v, %v; want 2 samples", gauges, err)
+	}
+}
+
+func Test_MemStorageOutOfOrderSamples(t *testing.T) {
+	db := storage.NewMemStorage(storage.DefaultHistoryLimit)
+
+	base := time.Now().Add(-time.Hour)
+	at := func(minutes int) *int64 {
+		ts := base.Add(time.Duration(minutes) * time.Minute).UnixMilli()
+		return &ts
+	}
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+
+	pack := model.MetricsPack{
+		{ID: "hits", MType: "counter", Delta: delta(1), TS: at(0)},
+		{ID: "hits", MType: "counter", Delta: delta(2), TS: at(20)},
+		{ID: "hits", MType: "counter", Delta: delta(4), TS: at(10)}, // запоздавшая дельта
+		{ID: "temp", MType: "gauge", Value: value(20), TS: at(20)},
+		{ID: "temp", MType: "gauge", Value: value(10), TS: at(10)}, // запоздавшее значение
+	}
+	if err := db.AddMetricsPack(&pack); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := db.GetCounterHistory("hits", base.Add(-time.Minute), base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.Counter{1, 5, 7}
+	if len(history) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(history), len(want))
+	}
+	for i, sample := range history {
+		if sample.Value != want[i] {
+			t.Errorf("sample %d: got %v, want %v", i, sample.Value, want[i])
+		}
+	}
+
+	if got, _ := db.GetCounterItem("hits"); got != 7 {
+		t.Errorf("counter: got %v, want 7", got)
+	}
+	if got, _ := db.GetGaugeItem("temp"); got != 20 {
+		t.Errorf("gauge: got %v, want 20", got)
+	}
+}
+
+// Test_MemStorageConcurrentReset запускается с -race: сбор метрик агента идёт параллельно с отправкой
+func Test_MemStorageConcurrentReset(t *testing.T) {
+	db := storage.NewMemStorage(0)
+	db.AddCounterItem("PollCount", 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				db.AddCounterItem("PollCount", 1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				db.ResetCounterItem("PollCount")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				db.GetCounterItems()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_MemStorageHistoryLimit(t *testing.T) {
+	db := storage.NewMemStorage(2)
+
+	base := time.Now().Add(-time.Hour)
+	at := func(minutes int) *int64 {
+		ts := base.Add(time.Duration(minutes) * time.Minute).UnixMilli()
+		return &ts
+	}
+	delta := func(v int64) *int64 { return &v }
+
+	pack := model.MetricsPack{
+		{ID: "hits", MType: "counter", Delta: delta(1), TS: at(10)},
+		{ID: "hits", MType: "counter", Delta: delta(2), TS: at(20)},
+		{ID: "hits", MType: "counter", Delta: delta(4), TS: at(30)},
+		// запоздавшая дельта старше отброшенного начала истории
+		{ID: "hits", MType: "counter", Delta: delta(8), TS: at(5)},
+	}
+	if err := db.AddMetricsPack(&pack); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := db.GetCounterHistory("hits", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.Counter{11, 15}
+	if len(history) != len(want) {
+		t.Fatalf("got %v, want values %v", history, want)
+	}
+	for i, sample := range history {
+		if sample.Value != want[i] {
+			t.Errorf("sample %d: got %v, want %v", i, sample.Value, want[i])
+		}
+	}
+
+	exported := db.ExportHistory()
+	if len(exported.TruncatedCounters) != 1 || exported.TruncatedCounters[0] != "hits" {
+		t.Errorf("got truncated counters %v, want [hits]", exported.TruncatedCounters)
+	}
+}
+
+func Test_MemStorageImportHistory(t *testing.T) {
+	source := storage.NewMemStorage(storage.DefaultHistoryLimit)
+	for i := 0; i < 3; i++ {
+		source.AddGaugeItem("Alloc", model.Gauge(i))
+	}
+
+	target := storage.NewMemStorage(2)
+	target.AddGaugeItem("Alloc", 2)
+	target.ImportHistory(source.ExportHistory())
+
+	now := time.Now()
+	history, err := target.GetGaugeHistory("Alloc", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Value != 1 || history[1].Value != 2 {
+		t.Errorf("got %v, want the last two samples 1 and 2", history)
+	}
+	if exported := target.ExportHistory(); len(exported.TruncatedGauges) != 1 {
+		t.Errorf("imported history over the limit is not marked as truncated")
+	}
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"net"
+	"strings"
+)
+
+/*
+IsLoopbackAddress сообщает, что адрес вида host:port слушает только локальный интерфейс.
+Пустой хост и 0.0.0.0 означают все интерфейсы, имя localhost считается локальным
+*/
+func IsLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrorNoPEMBlock   = errors.New("no PEM block found")
+	ErrorNotRSAKey    = errors.New("key is not an RSA key")
+	ErrorShortPayload = errors.New("encrypted payload is too short")
+)
+
+func readPEMBlock(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrorNoPEMBlock)
+	}
+	return block, nil
+}
+
+// LoadPublicKey читает публичный RSA ключ из PEM файла (PKIX, PKCS#1 или сертификат)
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrorNotRSAKey
+	}
+	return publicKey, nil
+}
+
+// LoadPrivateKey читает приватный RSA ключ из PEM файла (PKCS#1 или PKCS#8)
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrorNotRSAKey
+	}
+	return privateKey, nil
+}
+
+/*
+EncryptHybrid шифрует данные случайным ключом AES-256-GCM, а сам ключ - публичным RSA ключом (OAEP, SHA-256).
+Возвращает зашифрованный ключ и payload вида nonce || ciphertext.
+*/
+func EncryptHybrid(publicKey *rsa.PublicKey, data []byte) ([]byte, []byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, sessionKey, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encryptedKey, gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// DecryptHybrid расшифровывает данные, зашифрованные EncryptHybrid
+func DecryptHybrid(privateKey *rsa.PrivateKey, encryptedKey []byte, payload []byte) ([]byte, error) {
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, privateKey, encryptedKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) < gcm.NonceSize() {
+		return nil, ErrorShortPayload
+	}
+
+	nonce, ciphertext := payload[:gcm.NonceSize()], payload[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -15,3 +15,17 @@ func MakeHMACSign(key string, data []byte) []byte {
 func CheckHMACEqual(key string, sign []byte, data []byte) bool {
 	return hmac.Equal(MakeHMACSign(key, data), sign)
 }
+
+/*
+SignedPayload собирает данные для подписи запроса с защитой от повтора: метод, URI запроса,
+timestamp и nonce подписываются вместе с телом, чтобы подпись нельзя было перенести
+на другой маршрут или подменить время и nonce
+*/
+func SignedPayload(method string, requestURI string, timestamp string, nonce string, body []byte) []byte {
+	payload := make([]byte, 0, len(method)+len(requestURI)+len(timestamp)+len(nonce)+len(body)+4)
+	for _, field := range []string{method, requestURI, timestamp, nonce} {
+		payload = append(payload, field...)
+		payload = append(payload, '\n')
+	}
+	return append(payload, body...)
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/utils"
+)
+
+func Test_IsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"127.0.0.1:9125", true},
+		{"127.0.0.2:9125", true},
+		{"[::1]:9125", true},
+		{"localhost:9125", true},
+		{":9125", false},
+		{"0.0.0.0:9125", false},
+		{"[::]:9125", false},
+		{"192.168.1.10:9125", false},
+		{"example.com:9125", false},
+		{"127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := utils.IsLoopbackAddress(tt.address); got != tt.want {
+			t.Errorf("IsLoopbackAddress(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
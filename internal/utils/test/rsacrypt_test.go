@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/bbquite/mca-server/internal/utils"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func Test_EncryptHybrid(t *testing.T) {
+	key := generateKey(t)
+	data := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, plain := range [][]byte{data, {}} {
+			encryptedKey, payload, err := utils.EncryptHybrid(&key.PublicKey, plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := utils.DecryptHybrid(key, encryptedKey, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("got %q, want %q", got, plain)
+			}
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		encryptedKey, payload, err := utils.EncryptHybrid(&key.PublicKey, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := utils.DecryptHybrid(generateKey(t), encryptedKey, payload); err == nil {
+			t.Error("expected error for wrong private key")
+		}
+	})
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		encryptedKey, payload, err := utils.EncryptHybrid(&key.PublicKey, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload[len(payload)-1] ^= 0xff
+
+		if _, err := utils.DecryptHybrid(key, encryptedKey, payload); err == nil {
+			t.Error("expected error for tampered ciphertext")
+		}
+	})
+
+	t.Run("short payload", func(t *testing.T) {
+		encryptedKey, _, err := utils.EncryptHybrid(&key.PublicKey, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = utils.DecryptHybrid(key, encryptedKey, []byte{1, 2, 3})
+		if !errors.Is(err, utils.ErrorShortPayload) {
+			t.Errorf("got %v, want %v", err, utils.ErrorShortPayload)
+		}
+	})
+}
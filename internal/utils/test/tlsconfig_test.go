@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bbquite/mca-server/internal/utils"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPath string
+	keyPath  string
+}
+
+// issueCert выпускает сертификат для usage, подписанный parent (nil - самоподписанный CA), и сохраняет его в dir
+func issueCert(t *testing.T, dir string, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &testCert{
+		cert:     cert,
+		key:      key,
+		certPath: filepath.Join(dir, name+".pem"),
+		keyPath:  filepath.Join(dir, name+"-key.pem"),
+	}
+	writePEM(t, result.certPath, "CERTIFICATE", der)
+	writePEM(t, result.keyPath, "EC PRIVATE KEY", keyDER)
+	return result
+}
+
+func writePEM(t *testing.T, path string, blockType string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_NewServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, x509.ExtKeyUsageServerAuth)
+	server := issueCert(t, dir, "server", ca, x509.ExtKeyUsageServerAuth)
+
+	tests := []struct {
+		name     string
+		certPath string
+		keyPath  string
+		caPath   string
+		wantErr  error
+		wantNil  bool
+	}{
+		{name: "plain http", wantNil: true},
+		{name: "tls", certPath: server.certPath, keyPath: server.keyPath},
+		{name: "mtls", certPath: server.certPath, keyPath: server.keyPath, caPath: ca.certPath},
+		{name: "cert without key", certPath: server.certPath, wantErr: utils.ErrorIncompleteKeyPair},
+		{name: "key without cert", keyPath: server.keyPath, wantErr: utils.ErrorIncompleteKeyPair},
+		{name: "client ca without tls", caPath: ca.certPath, wantErr: utils.ErrorClientCAWithoutTLS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := utils.NewServerTLSConfig(test.certPath, test.keyPath, test.caPath)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (cfg == nil) != test.wantNil {
+				t.Errorf("got config %v, want nil: %v", cfg, test.wantNil)
+			}
+		})
+	}
+}
+
+func Test_MutualTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, x509.ExtKeyUsageServerAuth)
+	server := issueCert(t, dir, "server", ca, x509.ExtKeyUsageServerAuth)
+	client := issueCert(t, dir, "client", ca, x509.ExtKeyUsageClientAuth)
+
+	serverTLS, err := utils.NewServerTLSConfig(server.certPath, server.keyPath, ca.certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = serverTLS
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cfg *tls.Config) error {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		response, err := httpClient.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		return nil
+	}
+
+	t.Run("client certificate", func(t *testing.T) {
+		cfg, err := utils.NewClientTLSConfig(ca.certPath, client.certPath, client.keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := get(cfg); err != nil {
+			t.Errorf("handshake failed: %v", err)
+		}
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		cfg, err := utils.NewClientTLSConfig(ca.certPath, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := get(cfg); err == nil {
+			t.Error("expected handshake error without client certificate")
+		}
+	})
+
+	t.Run("unknown server ca", func(t *testing.T) {
+		otherCA := issueCert(t, t.TempDir(), "other", nil, x509.ExtKeyUsageServerAuth)
+		cfg, err := utils.NewClientTLSConfig(otherCA.certPath, client.certPath, client.keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := get(cfg); err == nil {
+			t.Error("expected verification error for unknown server CA")
+		}
+	})
+}
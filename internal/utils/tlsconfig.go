@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+var (
+	ErrorNoCertificates     = errors.New("no certificates found in CA file")
+	ErrorIncompleteKeyPair  = errors.New("certificate and key must be set together")
+	ErrorClientCAWithoutTLS = errors.New("client CA requires server certificate and key")
+)
+
+func loadCertPool(caPath string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrorNoCertificates
+	}
+	return pool, nil
+}
+
+/*
+NewServerTLSConfig возвращает настройки TLS сервера с сертификатом certPath/keyPath,
+при заданном clientCAPath клиенты обязаны предъявить сертификат, подписанный этим CA.
+Если не задано ни одного пути, возвращает nil - сервер работает по http
+*/
+func NewServerTLSConfig(certPath string, keyPath string, clientCAPath string) (*tls.Config, error) {
+	if certPath == "" && keyPath == "" {
+		if clientCAPath != "" {
+			return nil, ErrorClientCAWithoutTLS
+		}
+		return nil, nil
+	}
+
+	if certPath == "" || keyPath == "" {
+		return nil, ErrorIncompleteKeyPair
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if clientCAPath != "" {
+		pool, err := loadCertPool(clientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// NewClientTLSConfig возвращает настройки TLS агента: caPath - CA для проверки сервера
+// (пусто - системные корневые сертификаты), certPath/keyPath - клиентский сертификат для mTLS
+func NewClientTLSConfig(caPath string, certPath string, keyPath string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caPath != "" {
+		pool, err := loadCertPool(caPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certPath != "" || keyPath != "" {
+		if certPath == "" || keyPath == "" {
+			return nil, ErrorIncompleteKeyPair
+		}
+
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
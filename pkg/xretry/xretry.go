@@ -1,6 +1,8 @@
 package xretry
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
@@ -10,6 +12,16 @@ type RetryPolicy struct {
 	retriesWithBackoff int
 	delay              time.Duration
 	backoffFactor      float64
+	delays             []time.Duration
+	maxDelay           time.Duration
+	retryIf            func(error) bool
+}
+
+// RetryAfterError is implemented by errors that carry a server-requested delay
+// (e.g. the Retry-After HTTP header). A positive delay replaces the policy delay
+type RetryAfterError interface {
+	error
+	RetryAfter() time.Duration
 }
 
 // RetryPolicyOption is the option for the retry policy
@@ -31,6 +43,28 @@ func WithRetriesWithBackoff(retries int, delay time.Duration, backoffFactor floa
 	}
 }
 
+// WithDelays sets an explicit delay before each retry, e.g. 1s, 3s, 5s.
+// It takes precedence over WithRetriesWithBackoff
+func WithDelays(delays ...time.Duration) RetryPolicyOption {
+	return func(p *RetryPolicy) {
+		p.delays = delays
+	}
+}
+
+// WithMaxDelay limits a single delay, including the one requested by RetryAfterError
+func WithMaxDelay(maxDelay time.Duration) RetryPolicyOption {
+	return func(p *RetryPolicy) {
+		p.maxDelay = maxDelay
+	}
+}
+
+// WithRetryIf sets the predicate that decides whether an error is worth retrying
+func WithRetryIf(retryIf func(error) bool) RetryPolicyOption {
+	return func(p *RetryPolicy) {
+		p.retryIf = retryIf
+	}
+}
+
 // NewRetryPolicy creates a new RetryPolicy
 func NewRetryPolicy(opts ...RetryPolicyOption) RetryPolicy {
 	p := RetryPolicy{
@@ -61,6 +95,12 @@ func NewRetrier(p RetryPolicy) *Retrier {
 
 // Retry will retry the given function
 func (r *Retrier) Retry(f func() error) error {
+	return r.RetryContext(context.Background(), f)
+}
+
+// RetryContext will retry the given function until it succeeds, the policy is exhausted
+// or ctx is done. The last error of f is returned
+func (r *Retrier) RetryContext(ctx context.Context, f func() error) error {
 
 	// err := immediatelyRetry(f, r.p.immediateRetries)
 	// if err != nil {
@@ -70,26 +110,58 @@ func (r *Retrier) Retry(f func() error) error {
 	// 	}
 	// }
 
-	err := retryWithBackoff(f, r.p.retriesWithBackoff, r.p.delay, r.p.backoffFactor)
-	if err != nil {
-		return err
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		if r.p.retryIf != nil && !r.p.retryIf(err) {
+			return err
+		}
+
+		delay, ok := r.p.nextDelay(attempt)
+		if !ok {
+			return err
+		}
+
+		var retryAfterErr RetryAfterError
+		if errors.As(err, &retryAfterErr) && retryAfterErr.RetryAfter() > 0 {
+			delay = retryAfterErr.RetryAfter()
+		}
+
+		if r.p.maxDelay > 0 && delay > r.p.maxDelay {
+			delay = r.p.maxDelay
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
 	}
-
-	return nil
 }
 
-func retryWithBackoff(f func() error, retriesLeft int, delay time.Duration, backoff float64) error {
-	err := f()
-	if err == nil {
-		return nil
+// nextDelay returns the delay before the retry after the given failed attempt
+func (p RetryPolicy) nextDelay(attempt int) (time.Duration, bool) {
+	if p.delays != nil {
+		if attempt >= len(p.delays) {
+			return 0, false
+		}
+		return p.delays[attempt], true
 	}
 
-	if retriesLeft == 0 {
-		return err
+	if attempt >= p.retriesWithBackoff {
+		return 0, false
 	}
 
-	time.Sleep(delay)
-	return retryWithBackoff(f, retriesLeft-1, time.Duration(float64(delay)*backoff), backoff)
+	delay := p.delay
+	for i := 0; i < attempt; i++ {
+		delay = time.Duration(float64(delay) * p.backoffFactor)
+	}
+	return delay, true
 }
 
 func immediatelyRetry(f func() error, retriesLeft int) error {